@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+	"errors"
+	"slices"
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+)
+
+// authenticateUser validates the JWT from Authorization header and returns the
+// user ID from it. On failure the 401 response is already written.
+func (cfg *apiConfig) authenticateUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	claims, ok := cfg.authenticateToken(w, r)
+	return claims.UserID, ok
+}
+
+func (cfg *apiConfig) authenticateToken(w http.ResponseWriter, r *http.Request) (auth.TokenClaims, bool) {
+	token_from_header, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return auth.TokenClaims{}, false
+	}
+	claims, err := cfg.keyring.ValidateAccessToken(token_from_header)
+	if err != nil {
+		writeInvalidToken(w, err)
+		return auth.TokenClaims{}, false
+	}
+	return claims, true
+}
+
+// authenticateUserWithScope also accepts personal access tokens that have the
+// scope, JWTs from login can do everything the user can
+func (cfg *apiConfig) authenticateUserWithScope(w http.ResponseWriter, r *http.Request, scope string) (uuid.UUID, bool) {
+	token_from_header, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		writeAuthorizationError(w, err)
+		return uuid.Nil, false
+	}
+	claims, err := cfg.validateTokenWithScope(r.Context(), token_from_header, scope)
+	if errors.Is(err, errMissingScope) {
+		writeInsufficientScope(w, scope)
+		return uuid.Nil, false
+	}
+	if err != nil {
+		writeInvalidToken(w, err)
+		return uuid.Nil, false
+	}
+	return claims.UserID, true
+}
+
+var errMissingScope = errors.New("token does not have the required scope")
+
+// validateTokenWithScope validates JWT or personal access token and checks
+// that it has the scope
+func (cfg *apiConfig) validateTokenWithScope(ctx context.Context, token string, scope string) (auth.TokenClaims, error) {
+	claims, scopes, err := cfg.validateAnyToken(ctx, token)
+	if err != nil {
+		return auth.TokenClaims{}, err
+	}
+	if !slices.Contains(scopes, scope) {
+		return auth.TokenClaims{}, errMissingScope
+	}
+	return claims, nil
+}
+
+// validateAnyToken validates JWT or personal access token and returns its
+// scopes, JWTs from login have all of them. Personal access tokens don't
+// expire by default, ExpiresAt is at most one hour away so long lived
+// connections check them again.
+func (cfg *apiConfig) validateAnyToken(ctx context.Context, token string) (auth.TokenClaims, []string, error) {
+	if !auth.IsPersonalAccessToken(token) {
+		claims, err := cfg.keyring.ValidateAccessToken(token)
+		return claims, knownScopes, err
+	}
+
+	db_token, err := cfg.dbq.UsePersonalAccessToken(ctx, auth.HashOpaqueToken(token))
+	if err != nil {
+		return auth.TokenClaims{}, nil, fmt.Errorf("no valid personal access token found: %w", err)
+	}
+	// logout-all and password change revoke personal access tokens as well
+	revoked, err := cfg.denylist.IsRevokedAt(db_token.UserID, db_token.CreatedAt)
+	if err != nil {
+		return auth.TokenClaims{}, nil, fmt.Errorf("failed to check personal access token revocation: %w", err)
+	}
+	if revoked {
+		return auth.TokenClaims{}, nil, errors.New("personal access token has been revoked")
+	}
+
+	expires_at := time.Now().Add(time.Hour)
+	if db_token.ExpiresAt.Valid && db_token.ExpiresAt.Time.Before(expires_at) {
+		expires_at = db_token.ExpiresAt.Time
+	}
+	return auth.TokenClaims{
+		UserID:    db_token.UserID,
+		TokenID:   db_token.ID.String(),
+		IssuedAt:  db_token.CreatedAt,
+		ExpiresAt: expires_at,
+	}, db_token.Scopes, nil
+}
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// higher roles can do everything lower ones can
+var roleRanks = map[string]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// hasRole tells if user_role is the role or a higher one, unknown roles rank
+// as user
+func hasRole(user_role string, role string) bool {
+	return roleRanks[user_role] >= roleRanks[role]
+}
+
+type contextKey string
+
+const contextKeyUserID contextKey = "user_id"
+
+// middlewareRequireRole lets the request through only with JWT of a user that
+// has at least the role. The role is read from the database on every request
+// so removing it takes effect right away.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user_id, ok := cfg.authenticateUser(w, r)
+		if !ok {
+			return
+		}
+		user_role, err := cfg.dbq.GetUserRole(r.Context(), user_id)
+		if err != nil {
+			writeInvalidToken(w, fmt.Errorf("failed to get role of user %s: %w", user_id, err))
+			return
+		}
+		if !hasRole(user_role, role) {
+			log.Printf("User %s with role %s denied access to %s", user_id, user_role, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKeyUserID, user_id)))
+	})
+}
+
+// userIDFromContext gives the user authenticated by middlewareRequireRole
+func userIDFromContext(r *http.Request) uuid.UUID {
+	user_id, _ := r.Context().Value(contextKeyUserID).(uuid.UUID)
+	return user_id
+}
+
+// writeAuthorizationError writes 401 for missing or malformed Authorization
+// header with the WWW-Authenticate challenge of the expected scheme
+func writeAuthorizationError(w http.ResponseWriter, err error) {
+	log.Printf("Failed to extract token from header: %s", err)
+	auth_err := &auth.AuthorizationError{}
+	if errors.As(err, &auth_err) {
+		w.Header().Set("WWW-Authenticate", auth_err.Challenge())
+	}
+	w.WriteHeader(401)
+	w.Write([]byte("Failed to extract token from header"))
+}
+
+// writeInvalidToken writes 401 for bearer token that was well formed but
+// failed validation
+func writeInvalidToken(w http.ResponseWriter, err error) {
+	log.Printf("Token mismatch: %s", err)
+	w.Header().Set("WWW-Authenticate", auth.Challenge(auth.SchemeBearer, auth.ChallengeInvalidToken, ""))
+	w.WriteHeader(401)
+	w.Write([]byte("Invalid Token"))
+}
+
+func writeInsufficientScope(w http.ResponseWriter, scope string) {
+	w.Header().Set("WWW-Authenticate", auth.Challenge(auth.SchemeBearer, auth.ChallengeInsufficientScope, "")+`, scope="`+scope+`"`)
+	w.WriteHeader(403)
+	w.Write([]byte("Token does not have scope " + scope))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,509 @@
+package main
+
+import (
+	"io"
+	"log"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"strconv"
+	"net/url"
+	"net/http"
+	"html"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/activitypub"
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+const inboxMaxBodySize = 1 << 20
+const federationDeliveryTimeout = 30 * time.Second
+const outboxPageSize = 20
+const outboxMaxPage = 10000
+
+func (cfg *apiConfig) handlerWebFinger(w http.ResponseWriter, r *http.Request) {
+	resource := r.URL.Query().Get("resource")
+	account, found := strings.CutPrefix(resource, "acct:")
+	if !found {
+		log.Printf("Unsupported WebFinger resource: %s", resource)
+		w.WriteHeader(400)
+		return
+	}
+	at_index := strings.LastIndex(account, "@")
+	if at_index == -1 || account[at_index+1:] != cfg.publicHost() {
+		w.WriteHeader(404)
+		return
+	}
+
+	db_user, ok := cfg.getFederatedUser(w, r, account[:at_index])
+	if !ok {
+		return
+	}
+
+	actor_url := cfg.actorURL(db_user.ID)
+	webfinger := activitypub.WebFinger{
+		Subject: "acct:" + actorUsername(db_user.ID) + "@" + cfg.publicHost(),
+		Aliases: []string{actor_url},
+		Links: []activitypub.WebFingerLink{
+			{
+				Rel:  "self",
+				Type: activitypub.ContentType,
+				Href: actor_url,
+			},
+		},
+	}
+	writeActivityJSON(w, activitypub.JRDContentType, webfinger)
+}
+
+func (cfg *apiConfig) handlerActor(w http.ResponseWriter, r *http.Request) {
+	db_user, ok := cfg.getFederatedUser(w, r, r.PathValue("userID"))
+	if !ok {
+		return
+	}
+
+	actor_key, err := cfg.getActorKey(r.Context(), db_user.ID)
+	if err != nil {
+		log.Printf("Error getting actor key: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	actor_url := cfg.actorURL(db_user.ID)
+	actor := activitypub.Actor{
+		Context:           []string{activitypub.ActivityStreamsNS, activitypub.SecurityNS},
+		ID:                actor_url,
+		Type:              "Person",
+		PreferredUsername: actorUsername(db_user.ID),
+		Name:              "Chirpy user " + actorUsername(db_user.ID),
+		Inbox:             actor_url + "/inbox",
+		Outbox:            actor_url + "/outbox",
+		Followers:         actor_url + "/followers",
+		PublicKey: &activitypub.PublicKey{
+			ID:           actorKeyID(actor_url),
+			Owner:        actor_url,
+			PublicKeyPem: actor_key.PublicKeyPem,
+		},
+	}
+	writeActivityJSON(w, activitypub.ContentType, actor)
+}
+
+// handlerOutbox returns the collection with only the count and link to the
+// first page, ?page=N gives the chirps newest first
+func (cfg *apiConfig) handlerOutbox(w http.ResponseWriter, r *http.Request) {
+	db_user, ok := cfg.getFederatedUser(w, r, r.PathValue("userID"))
+	if !ok {
+		return
+	}
+	outbox_url := cfg.actorURL(db_user.ID) + "/outbox"
+
+	if !r.URL.Query().Has("page") {
+		total_items, err := cfg.dbq.CountUserChirps(r.Context(), db_user.ID)
+		if err != nil {
+			log.Printf("Error counting chirps: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		writeActivityJSON(w, activitypub.ContentType, activitypub.PagedCollection{
+			Context:    activitypub.ActivityStreamsNS,
+			ID:         outbox_url,
+			Type:       "OrderedCollection",
+			TotalItems: int(total_items),
+			First:      outbox_url + "?page=1",
+		})
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 || page > outboxMaxPage {
+		w.WriteHeader(400)
+		return
+	}
+	// one extra chirp tells if there is a next page
+	db_chirps, err := cfg.dbq.GetUserChirpsPage(r.Context(), database.GetUserChirpsPageParams{
+		UserID: db_user.ID,
+		Limit:  outboxPageSize + 1,
+		Offset: int32((page - 1) * outboxPageSize),
+	})
+	if err != nil {
+		log.Printf("Error getting chirps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	outbox_page := activitypub.OrderedCollectionPage{
+		Context:      activitypub.ActivityStreamsNS,
+		ID:           outbox_url + "?page=" + strconv.Itoa(page),
+		Type:         "OrderedCollectionPage",
+		PartOf:       outbox_url,
+		OrderedItems: make([]interface{}, 0, outboxPageSize),
+	}
+	if len(db_chirps) > outboxPageSize {
+		db_chirps = db_chirps[:outboxPageSize]
+		outbox_page.Next = outbox_url + "?page=" + strconv.Itoa(page+1)
+	}
+	if page > 1 {
+		outbox_page.Prev = outbox_url + "?page=" + strconv.Itoa(page-1)
+	}
+	for _, db_chirp := range db_chirps {
+		create_activity, err := cfg.chirpActivity("Create", db_chirp)
+		if err != nil {
+			log.Printf("Error building activity: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		outbox_page.OrderedItems = append(outbox_page.OrderedItems, create_activity)
+	}
+	writeActivityJSON(w, activitypub.ContentType, outbox_page)
+}
+
+func (cfg *apiConfig) handlerFollowers(w http.ResponseWriter, r *http.Request) {
+	db_user, ok := cfg.getFederatedUser(w, r, r.PathValue("userID"))
+	if !ok {
+		return
+	}
+
+	db_followers, err := cfg.dbq.GetFollowers(r.Context(), db_user.ID)
+	if err != nil {
+		log.Printf("Error getting followers: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	items := make([]interface{}, 0, len(db_followers))
+	for _, db_follower := range db_followers {
+		items = append(items, db_follower.ActorID)
+	}
+
+	followers := activitypub.OrderedCollection{
+		Context:      activitypub.ActivityStreamsNS,
+		ID:           cfg.actorURL(db_user.ID) + "/followers",
+		Type:         "OrderedCollection",
+		TotalItems:   len(items),
+		OrderedItems: items,
+	}
+	writeActivityJSON(w, activitypub.ContentType, followers)
+}
+
+func (cfg *apiConfig) handlerActivityPubNote(w http.ResponseWriter, r *http.Request) {
+	c_uuid, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	db_chirp, err := cfg.dbq.GetOneChirp(r.Context(), c_uuid)
+	if err != nil {
+		log.Printf("Error getting chirp: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	note := cfg.chirpNote(db_chirp)
+	note.Context = activitypub.ActivityStreamsNS
+	writeActivityJSON(w, activitypub.ContentType, note)
+}
+
+func (cfg *apiConfig) handlerInbox(w http.ResponseWriter, r *http.Request) {
+	db_user, ok := cfg.getFederatedUser(w, r, r.PathValue("userID"))
+	if !ok {
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, inboxMaxBodySize))
+	if err != nil {
+		log.Printf("Error reading inbox body: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	signer := activitypub.Actor{}
+	_, err = activitypub.VerifyRequest(r, body, func(key_id string) (*rsa.PublicKey, error) {
+		public_key, actor, err := cfg.ap_client.FetchPublicKey(r.Context(), key_id)
+		signer = actor
+		return public_key, err
+	})
+	if err != nil {
+		log.Printf("Inbox signature verification failed: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	activity := activitypub.Activity{}
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		log.Printf("Error decoding activity: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if activity.Actor != signer.ID {
+		log.Printf("Activity actor %s does not match signer %s", activity.Actor, signer.ID)
+		w.WriteHeader(401)
+		return
+	}
+
+	switch activity.Type {
+	case "Follow":
+		if activitypub.ObjectID(activity.Object) != cfg.actorURL(db_user.ID) {
+			w.WriteHeader(400)
+			return
+		}
+		err = cfg.dbq.AddFollower(r.Context(), database.AddFollowerParams{
+			UserID:  db_user.ID,
+			ActorID: signer.ID,
+			Inbox:   signer.Inbox,
+		})
+		if err != nil {
+			log.Printf("Error adding follower: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		go cfg.acceptFollow(db_user.ID, signer.Inbox, body)
+	case "Undo":
+		undone_activity := activitypub.Activity{}
+		err = json.Unmarshal(activity.Object, &undone_activity)
+		if err != nil || undone_activity.Type != "Follow" || undone_activity.Actor != signer.ID {
+			// only follows are undone, anything else is acknowledged and ignored
+			break
+		}
+		err = cfg.dbq.RemoveFollower(r.Context(), database.RemoveFollowerParams{
+			UserID:  db_user.ID,
+			ActorID: signer.ID,
+		})
+		if err != nil {
+			log.Printf("Error removing follower: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	case "Create":
+		note := activitypub.Note{}
+		err = json.Unmarshal(activity.Object, &note)
+		if err != nil || note.Type != "Note" || note.ID == "" {
+			break
+		}
+		if note.AttributedTo != "" && note.AttributedTo != signer.ID {
+			log.Printf("Note %s is not attributed to signer %s", note.ID, signer.ID)
+			w.WriteHeader(401)
+			return
+		}
+		published_at := sql.NullTime{}
+		published, err := time.Parse(time.RFC3339, note.Published)
+		if err == nil {
+			published_at = sql.NullTime{Time: published, Valid: true}
+		}
+		err = cfg.dbq.CreateRemoteNote(r.Context(), database.CreateRemoteNoteParams{
+			ID:          note.ID,
+			ActorID:     signer.ID,
+			Content:     note.Content,
+			PublishedAt: published_at,
+		})
+		if err != nil {
+			log.Printf("Error storing remote note: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	default:
+		log.Printf("Ignoring unsupported activity type '%s'", activity.Type)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) acceptFollow(user_id uuid.UUID, inbox string, follow_body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), federationDeliveryTimeout)
+	defer cancel()
+
+	private_key, key_id, err := cfg.getSigningKey(ctx, user_id)
+	if err != nil {
+		log.Printf("Error getting actor key: %s", err)
+		return
+	}
+
+	accept := activitypub.Activity{
+		Context: activitypub.ActivityStreamsNS,
+		ID:      cfg.actorURL(user_id) + "#accepts/" + uuid.NewString(),
+		Type:    "Accept",
+		Actor:   cfg.actorURL(user_id),
+		Object:  follow_body,
+	}
+	err = cfg.ap_client.Deliver(ctx, inbox, accept, key_id, private_key)
+	if err != nil {
+		log.Printf("Failed to deliver Accept to %s: %s", inbox, err)
+	}
+}
+
+// federateChirp sends Create or Delete activity of the chirp to the authors followers
+func (cfg *apiConfig) federateChirp(activity_type string, db_chirp database.Chirp) {
+	if cfg.public_url == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), federationDeliveryTimeout)
+		defer cancel()
+
+		db_followers, err := cfg.dbq.GetFollowers(ctx, db_chirp.UserID)
+		if err != nil {
+			log.Printf("Error getting followers: %s", err)
+			return
+		}
+		if len(db_followers) == 0 {
+			return
+		}
+
+		private_key, key_id, err := cfg.getSigningKey(ctx, db_chirp.UserID)
+		if err != nil {
+			log.Printf("Error getting actor key: %s", err)
+			return
+		}
+
+		activity, err := cfg.chirpActivity(activity_type, db_chirp)
+		if err != nil {
+			log.Printf("Error building activity: %s", err)
+			return
+		}
+
+		delivered_inboxes := make(map[string]bool)
+		for _, db_follower := range db_followers {
+			if delivered_inboxes[db_follower.Inbox] {
+				continue
+			}
+			delivered_inboxes[db_follower.Inbox] = true
+			err = cfg.ap_client.Deliver(ctx, db_follower.Inbox, activity, key_id, private_key)
+			if err != nil {
+				log.Printf("Failed to deliver %s to %s: %s", activity_type, db_follower.Inbox, err)
+			}
+		}
+	}()
+}
+
+func (cfg *apiConfig) chirpActivity(activity_type string, db_chirp database.Chirp) (activitypub.Activity, error) {
+	note := cfg.chirpNote(db_chirp)
+	if activity_type == "Delete" {
+		note = activitypub.Note{
+			ID:   note.ID,
+			Type: "Tombstone",
+		}
+	}
+	note_data, err := json.Marshal(note)
+	if err != nil {
+		return activitypub.Activity{}, err
+	}
+
+	actor_url := cfg.actorURL(db_chirp.UserID)
+	return activitypub.Activity{
+		Context:   activitypub.ActivityStreamsNS,
+		ID:        note.ID + "#" + strings.ToLower(activity_type),
+		Type:      activity_type,
+		Actor:     actor_url,
+		Object:    note_data,
+		Published: db_chirp.CreatedAt.UTC().Format(time.RFC3339),
+		To:        []string{activitypub.PublicCollection},
+		Cc:        []string{actor_url + "/followers"},
+	}, nil
+}
+
+func (cfg *apiConfig) chirpNote(db_chirp database.Chirp) activitypub.Note {
+	actor_url := cfg.actorURL(db_chirp.UserID)
+	return activitypub.Note{
+		ID:           cfg.public_url + "/ap/chirps/" + db_chirp.ID.String(),
+		Type:         "Note",
+		AttributedTo: actor_url,
+		Content:      "<p>" + html.EscapeString(db_chirp.Body) + "</p>",
+		Published:    db_chirp.CreatedAt.UTC().Format(time.RFC3339),
+		To:           []string{activitypub.PublicCollection},
+		Cc:           []string{actor_url + "/followers"},
+	}
+}
+
+func (cfg *apiConfig) getFederatedUser(w http.ResponseWriter, r *http.Request, username string) (database.User, bool) {
+	user_uuid, err := uuid.Parse(username)
+	if err != nil {
+		w.WriteHeader(404)
+		return database.User{}, false
+	}
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_uuid)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(404)
+		return database.User{}, false
+	}
+	return db_user, true
+}
+
+// getActorKey returns the users federation key pair, creating it on first use
+func (cfg *apiConfig) getActorKey(ctx context.Context, user_id uuid.UUID) (database.ActorKey, error) {
+	actor_key, err := cfg.dbq.GetActorKey(ctx, user_id)
+	if err == nil {
+		return actor_key, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.ActorKey{}, err
+	}
+
+	public_pem, private_pem, err := activitypub.GenerateKeyPair()
+	if err != nil {
+		return database.ActorKey{}, err
+	}
+	actor_key, err = cfg.dbq.CreateActorKey(ctx, database.CreateActorKeyParams{
+		UserID:        user_id,
+		PublicKeyPem:  public_pem,
+		PrivateKeyPem: private_pem,
+	})
+	if err != nil {
+		// another request might have created the key at the same time
+		return cfg.dbq.GetActorKey(ctx, user_id)
+	}
+	return actor_key, nil
+}
+
+func (cfg *apiConfig) getSigningKey(ctx context.Context, user_id uuid.UUID) (*rsa.PrivateKey, string, error) {
+	actor_key, err := cfg.getActorKey(ctx, user_id)
+	if err != nil {
+		return nil, "", err
+	}
+	private_key, err := activitypub.ParsePrivateKeyPEM(actor_key.PrivateKeyPem)
+	if err != nil {
+		return nil, "", err
+	}
+	return private_key, actorKeyID(cfg.actorURL(user_id)), nil
+}
+
+func (cfg *apiConfig) actorURL(user_id uuid.UUID) string {
+	return cfg.public_url + "/ap/users/" + actorUsername(user_id)
+}
+
+func (cfg *apiConfig) publicHost() string {
+	parsed_url, err := url.Parse(cfg.public_url)
+	if err != nil {
+		return ""
+	}
+	return parsed_url.Host
+}
+
+func actorKeyID(actor_url string) string {
+	return actor_url + "#main-key"
+}
+
+// actorUsername is the user ID without dashes since most fediverse servers
+// only allow letters, numbers and underscores in usernames
+func actorUsername(user_id uuid.UUID) string {
+	return strings.ReplaceAll(user_id.String(), "-", "")
+}
+
+func writeActivityJSON(w http.ResponseWriter, content_type string, data interface{}) {
+	response_data, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", content_type)
+	w.WriteHeader(http.StatusOK)
+	w.Write(response_data)
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/t6kke/chirpy/internal/database"
-	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/pubsub"
 )
 
 type Chirp struct {
@@ -123,24 +123,17 @@ func (cfg *apiConfig) handlerAddChirp(w http.ResponseWriter, r *http.Request) {
 		Error string `json:"error"`
 	}
 
-	token_from_header, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Failed to extract token from header: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Failed to extract token from header"))
+	user_id_from_token, ok := cfg.authenticateUserWithScope(w, r, scopeChirpsWrite)
+	if !ok {
 		return
 	}
-	user_id_from_token, err := auth.ValidateJWT(token_from_header, cfg.c_secret)
-	if err != nil {
-		log.Printf("Token mismatch: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Invalid Token"))
+	if !cfg.requireVerifiedEmail(w, r, user_id_from_token) {
 		return
 	}
 
 	decoder := json.NewDecoder(r.Body)
 	c_body := chirp_body{}
-	err = decoder.Decode(&c_body)
+	err := decoder.Decode(&c_body)
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err)
 		w.WriteHeader(500)
@@ -177,6 +170,9 @@ func (cfg *apiConfig) handlerAddChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg.publishChirpEvent(pubsub.EventChirpCreated, db_chirp)
+	cfg.federateChirp("Create", db_chirp)
+
 	response_chirp := Chirp{
 		ID:        db_chirp.ID,
 		CreatedAt: db_chirp.CreatedAt,
@@ -204,18 +200,8 @@ func (cfg *apiConfig) handlerDeleteOneChirp(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	token_from_header, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Failed to extract token from header: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Failed to extract token from header"))
-		return
-	}
-	user_id_from_token, err := auth.ValidateJWT(token_from_header, cfg.c_secret)
-	if err != nil {
-		log.Printf("Token mismatch: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Invalid Token"))
+	user_id_from_token, ok := cfg.authenticateUserWithScope(w, r, scopeChirpsWrite)
+	if !ok {
 		return
 	}
 
@@ -232,13 +218,40 @@ func (cfg *apiConfig) handlerDeleteOneChirp(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = cfg.dbq.DeleteOneChirp(r.Context(), c_uuid)
+	cfg.deleteChirp(w, r, db_chirp)
+}
+
+// handlerModerateDeleteChirp lets moderators delete chirps of any user
+func (cfg *apiConfig) handlerModerateDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	c_uuid, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	db_chirp, err := cfg.dbq.GetOneChirp(r.Context(), c_uuid)
+	if err != nil {
+		log.Printf("Error getting chirp: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	log.Printf("Moderator %s deleting chirp %s of user %s", userIDFromContext(r), db_chirp.ID, db_chirp.UserID)
+	cfg.deleteChirp(w, r, db_chirp)
+}
+
+func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request, db_chirp database.Chirp) {
+	err := cfg.dbq.DeleteOneChirp(r.Context(), db_chirp.ID)
 	if err != nil {
 		log.Printf("Error deleting chirp from DB: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	cfg.publishChirpEvent(pubsub.EventChirpDeleted, db_chirp)
+	cfg.federateChirp("Delete", db_chirp)
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"context"
+	"net/url"
+	"net/http"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+	"github.com/t6kke/chirpy/internal/mailer"
+)
+
+const (
+	UnverifiedPolicyAllow     = "allow"
+	UnverifiedPolicyNoPosting = "no-posting"
+)
+
+// sendEmailVerification stores new verification token for the email and
+// mails the verification link in the background
+func (cfg *apiConfig) sendEmailVerification(r *http.Request, user_id uuid.UUID, email string) error {
+	verification_token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		return err
+	}
+	err = cfg.dbq.CreateEmailVerificationToken(r.Context(), database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashOpaqueToken(verification_token),
+		Email:     email,
+		UserID:    user_id,
+	})
+	if err != nil {
+		return err
+	}
+
+	// only the log mailer is allowed without PUBLIC_URL, the link is never
+	// built from the Host header so it can't point to someone else's server
+	base_url := cfg.public_url
+	if base_url == "" {
+		base_url = "http://localhost:8080"
+	}
+	message := mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email",
+		Body:    "Open this link within 48 hours to verify your email address:\n\n" +
+			base_url + "/api/verify-email?token=" + url.QueryEscape(verification_token) + "\n",
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := cfg.mailer.Send(ctx, message)
+		if err != nil {
+			log.Printf("Failed to send verification email to user %s: %s", user_id, err)
+		}
+	}()
+	return nil
+}
+
+func (cfg *apiConfig) handlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	verification_token := r.URL.Query().Get("token")
+	if verification_token == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("token is required"))
+		return
+	}
+
+	db_token, err := cfg.dbq.UseEmailVerificationToken(r.Context(), auth.HashOpaqueToken(verification_token))
+	if err != nil {
+		log.Printf("No valid verification token found: %s", err)
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid or expired verification token"))
+		return
+	}
+
+	_, err = cfg.dbq.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+		ID:    db_token.UserID,
+		Email: db_token.Email,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(400)
+		w.Write([]byte("Email has been changed after the verification was sent"))
+		return
+	}
+	if err != nil {
+		log.Printf("Error marking email verified: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Email verified"))
+}
+
+func (cfg *apiConfig) handlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+	if db_user.EmailVerifiedAt.Valid {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// only the newest link works
+	err = cfg.dbq.DeleteUserEmailVerificationTokens(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error deleting verification tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.sendEmailVerification(r, user_id, db_user.Email)
+	if err != nil {
+		log.Printf("Error creating verification token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireVerifiedEmail applies UNVERIFIED_EMAIL_POLICY to actions unverified
+// users may be restricted from. On failure the 403 response is already written.
+func (cfg *apiConfig) requireVerifiedEmail(w http.ResponseWriter, r *http.Request, user_id uuid.UUID) bool {
+	if cfg.unverified_policy != UnverifiedPolicyNoPosting {
+		return true
+	}
+
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(500)
+		return false
+	}
+	if db_user.EmailVerifiedAt.Valid {
+		return true
+	}
+
+	writeJSON(w, http.StatusForbidden, map[string]string{"error": "Email address needs to be verified"})
+	return false
+}
+
+func writeInvalidEmail(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"log"
+	"time"
+	"bytes"
+	"net/http"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+const feedMaxItems = 50
+const feedTitleLength = 40
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Description string  `xml:"description"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink string `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Link      atomLink    `xml:"link"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type feedInfo struct {
+	title       string
+	description string
+	baseURL     string
+	link        string
+	// Atom needs updated time even without chirps
+	createdAt   time.Time
+	chirps      []database.Chirp
+}
+
+func (cfg *apiConfig) handlerGlobalFeedRSS(w http.ResponseWriter, r *http.Request) {
+	feed_info, ok := cfg.getGlobalFeedInfo(w, r)
+	if !ok {
+		return
+	}
+	serveRSSFeed(w, r, feed_info)
+}
+
+func (cfg *apiConfig) handlerGlobalFeedAtom(w http.ResponseWriter, r *http.Request) {
+	feed_info, ok := cfg.getGlobalFeedInfo(w, r)
+	if !ok {
+		return
+	}
+	serveAtomFeed(w, r, feed_info)
+}
+
+func (cfg *apiConfig) handlerUserFeedRSS(w http.ResponseWriter, r *http.Request) {
+	feed_info, ok := cfg.getUserFeedInfo(w, r)
+	if !ok {
+		return
+	}
+	serveRSSFeed(w, r, feed_info)
+}
+
+func (cfg *apiConfig) handlerUserFeedAtom(w http.ResponseWriter, r *http.Request) {
+	feed_info, ok := cfg.getUserFeedInfo(w, r)
+	if !ok {
+		return
+	}
+	serveAtomFeed(w, r, feed_info)
+}
+
+func (cfg *apiConfig) getGlobalFeedInfo(w http.ResponseWriter, r *http.Request) (feedInfo, bool) {
+	db_chirps, err := cfg.dbq.GetNewestChirps(r.Context(), feedMaxItems)
+	if err != nil {
+		log.Printf("Error getting chirps: %s", err)
+		w.WriteHeader(500)
+		return feedInfo{}, false
+	}
+
+	base_url := cfg.baseURL(r)
+	return feedInfo{
+		title:       "Chirpy",
+		description: "Latest chirps from everyone",
+		baseURL:     base_url,
+		link:        base_url + "/api/chirps",
+		createdAt:   time.Unix(0, 0),
+		chirps:      db_chirps,
+	}, true
+}
+
+func (cfg *apiConfig) getUserFeedInfo(w http.ResponseWriter, r *http.Request) (feedInfo, bool) {
+	user_uuid, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return feedInfo{}, false
+	}
+
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_uuid)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(404)
+		return feedInfo{}, false
+	}
+
+	db_chirps, err := cfg.dbq.GetNewestUserChirps(r.Context(), database.GetNewestUserChirpsParams{
+		UserID: user_uuid,
+		Limit:  feedMaxItems,
+	})
+	if err != nil {
+		log.Printf("Error getting chirps: %s", err)
+		w.WriteHeader(500)
+		return feedInfo{}, false
+	}
+
+	base_url := cfg.baseURL(r)
+	return feedInfo{
+		title:       "Chirpy - " + user_uuid.String(),
+		description: "Latest chirps from user " + user_uuid.String(),
+		baseURL:     base_url,
+		link:        base_url + "/api/chirps?author_id=" + user_uuid.String(),
+		createdAt:   db_user.CreatedAt,
+		chirps:      db_chirps,
+	}, true
+}
+
+func serveRSSFeed(w http.ResponseWriter, r *http.Request, feed_info feedInfo) {
+	base_url := feed_info.baseURL
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       feed_info.title,
+			Link:        feed_info.link,
+			Description: feed_info.description,
+			Items:       make([]rssItem, 0, len(feed_info.chirps)),
+		},
+	}
+	last_modified := feedLastModified(feed_info.chirps)
+	if !last_modified.IsZero() {
+		feed.Channel.LastBuildDate = last_modified.UTC().Format(time.RFC1123Z)
+	}
+
+	for _, db_chirp := range feed_info.chirps {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       chirpTitle(db_chirp.Body),
+			Link:        base_url + "/api/chirps/" + db_chirp.ID.String(),
+			Description: db_chirp.Body,
+			GUID: rssGUID{
+				IsPermaLink: "false",
+				Value:       db_chirp.ID.URN(),
+			},
+			PubDate: db_chirp.CreatedAt.UTC().Format(time.RFC1123Z),
+		})
+	}
+
+	serveFeed(w, r, "application/rss+xml; charset=utf-8", feed, last_modified)
+}
+
+func serveAtomFeed(w http.ResponseWriter, r *http.Request, feed_info feedInfo) {
+	base_url := feed_info.baseURL
+	last_modified := feedLastModified(feed_info.chirps)
+	updated := last_modified
+	if updated.IsZero() {
+		updated = feed_info.createdAt
+	}
+	feed := atomFeed{
+		ID:      base_url + r.URL.Path,
+		Title:   feed_info.title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Link: atomLink{
+			Href: base_url + r.URL.Path,
+			Rel:  "self",
+		},
+		Entries: make([]atomEntry, 0, len(feed_info.chirps)),
+	}
+
+	for _, db_chirp := range feed_info.chirps {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:    db_chirp.ID.URN(),
+			Title: chirpTitle(db_chirp.Body),
+			Link: atomLink{
+				Href: base_url + "/api/chirps/" + db_chirp.ID.String(),
+			},
+			Published: db_chirp.CreatedAt.UTC().Format(time.RFC3339),
+			Updated:   db_chirp.UpdatedAt.UTC().Format(time.RFC3339),
+			Author: atomAuthor{
+				Name: db_chirp.UserID.String(),
+			},
+			Content: atomContent{
+				Type:  "text",
+				Value: db_chirp.Body,
+			},
+		})
+	}
+
+	serveFeed(w, r, "application/atom+xml; charset=utf-8", feed, last_modified)
+}
+
+// serveFeed marshals the feed and lets http.ServeContent answer conditional
+// requests using the ETag and Last-Modified values
+func serveFeed(w http.ResponseWriter, r *http.Request, content_type string, feed interface{}, last_modified time.Time) {
+	feed_data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		log.Printf("Error marshalling XML: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	response_data := append([]byte(xml.Header), feed_data...)
+
+	// ETag covers deletions as well which Last-Modified alone would miss
+	etag_hash := sha256.Sum256(response_data)
+	w.Header().Set("ETag", "\""+hex.EncodeToString(etag_hash[:16])+"\"")
+	w.Header().Set("Content-Type", content_type)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", last_modified, bytes.NewReader(response_data))
+}
+
+func feedLastModified(db_chirps []database.Chirp) time.Time {
+	last_modified := time.Time{}
+	for _, db_chirp := range db_chirps {
+		if db_chirp.UpdatedAt.After(last_modified) {
+			last_modified = db_chirp.UpdatedAt
+		}
+	}
+	return last_modified
+}
+
+func chirpTitle(body string) string {
+	if utf8.RuneCountInString(body) <= feedTitleLength {
+		return body
+	}
+	return string([]rune(body)[:feedTitleLength]) + "..."
+}
+
+// baseURL is PUBLIC_URL, the address from the request is only used when it's
+// not set so links can't be changed with the Host header in production
+func (cfg *apiConfig) baseURL(r *http.Request) string {
+	if cfg.public_url != "" {
+		return cfg.public_url
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"time"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+	"encoding/xml"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+func testFeedInfo(chirps []database.Chirp) feedInfo {
+	return feedInfo{
+		title:       "Chirpy",
+		description: "Latest chirps from everyone",
+		baseURL:     "https://chirpy.test",
+		link:        "https://chirpy.test/api/chirps",
+		createdAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		chirps:      chirps,
+	}
+}
+
+func TestServeFeedEscaping(t *testing.T) {
+	body := `<script>alert("hi")</script> Tom & Jerry`
+	chirps := []database.Chirp{{
+		ID:        uuid.New(),
+		CreatedAt: time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+		UpdatedAt: time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC),
+		Body:      body,
+		UserID:    uuid.New(),
+	}}
+
+	rss_recorder := httptest.NewRecorder()
+	serveRSSFeed(rss_recorder, httptest.NewRequest("GET", "/api/feed.rss", nil), testFeedInfo(chirps))
+	atom_recorder := httptest.NewRecorder()
+	serveAtomFeed(atom_recorder, httptest.NewRequest("GET", "/api/feed.atom", nil), testFeedInfo(chirps))
+
+	for _, raw := range []string{rss_recorder.Body.String(), atom_recorder.Body.String()} {
+		if strings.Contains(raw, "<script>") {
+			t.Errorf("feed contains unescaped chirp body:\n%s", raw)
+		}
+	}
+
+	rss := rssFeed{}
+	err := xml.Unmarshal(rss_recorder.Body.Bytes(), &rss)
+	if err != nil {
+		t.Fatalf("RSS feed is not valid XML: %v", err)
+	}
+	if len(rss.Channel.Items) != 1 || rss.Channel.Items[0].Description != body {
+		t.Errorf("RSS items = %+v, want description %q", rss.Channel.Items, body)
+	}
+
+	atom := atomFeed{}
+	err = xml.Unmarshal(atom_recorder.Body.Bytes(), &atom)
+	if err != nil {
+		t.Fatalf("Atom feed is not valid XML: %v", err)
+	}
+	if len(atom.Entries) != 1 || atom.Entries[0].Content.Value != body {
+		t.Errorf("Atom entries = %+v, want content %q", atom.Entries, body)
+	}
+}
+
+func TestServeFeedConditionalGet(t *testing.T) {
+	updated_at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	chirps := []database.Chirp{{
+		ID:        uuid.New(),
+		CreatedAt: updated_at,
+		UpdatedAt: updated_at,
+		Body:      "hello",
+		UserID:    uuid.New(),
+	}}
+
+	recorder := httptest.NewRecorder()
+	serveRSSFeed(recorder, httptest.NewRequest("GET", "/api/feed.rss", nil), testFeedInfo(chirps))
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first request got status %d and ETag %q", recorder.Code, etag)
+	}
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{"Matching ETag", "If-None-Match", etag, http.StatusNotModified},
+		{"Other ETag", "If-None-Match", `"other"`, http.StatusOK},
+		{"Not modified since", "If-Modified-Since", updated_at.Format(http.TimeFormat), http.StatusNotModified},
+		{"Modified since", "If-Modified-Since", updated_at.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/feed.rss", nil)
+			req.Header.Set(tt.header, tt.value)
+			recorder := httptest.NewRecorder()
+			serveRSSFeed(recorder, req, testFeedInfo(chirps))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestServeAtomFeedEmpty(t *testing.T) {
+	feed_info := testFeedInfo(nil)
+	recorder := httptest.NewRecorder()
+	serveAtomFeed(recorder, httptest.NewRequest("GET", "/api/feed.atom", nil), feed_info)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	atom := atomFeed{}
+	err := xml.Unmarshal(recorder.Body.Bytes(), &atom)
+	if err != nil {
+		t.Fatalf("Atom feed is not valid XML: %v", err)
+	}
+	want := feed_info.createdAt.Format(time.RFC3339)
+	if atom.Updated != want {
+		t.Errorf("updated = %q, want %q", atom.Updated, want)
+	}
+	if len(atom.Entries) != 0 {
+		t.Errorf("got %d entries, want none", len(atom.Entries))
+	}
+}
+
+// links come from PUBLIC_URL so the Host header can't change them, the
+// request's host is only used when PUBLIC_URL is not set
+func TestFeedBaseURL(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/feed.rss", nil)
+	req.Host = "attacker.test"
+
+	tests := []struct {
+		name      string
+		publicURL string
+		want      string
+	}{
+		{"Public URL set", "https://chirpy.test", "https://chirpy.test"},
+		{"Public URL not set", "", "http://attacker.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &apiConfig{public_url: tt.publicURL}
+			if got := cfg.baseURL(req); got != tt.want {
+				t.Errorf("baseURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"net/http"
+	"crypto/sha256"
+	"crypto/subtle"
+
+	"github.com/t6kke/chirpy/internal/auth"
+)
+
+// IntrospectionResponse is RFC 7662 introspection response, inactive tokens
+// only have active false
+type IntrospectionResponse struct {
+	Active    bool      `json:"active"`
+	Scope     string    `json:"scope,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	TokenType string    `json:"token_type,omitempty"`
+	Exp       int64     `json:"exp,omitempty"`
+	Iat       int64     `json:"iat,omitempty"`
+	Sub       string    `json:"sub,omitempty"`
+	Iss       string    `json:"iss,omitempty"`
+	Jti       string    `json:"jti,omitempty"`
+}
+
+// handlerIntrospect lets other services check chirpy access tokens and
+// personal access tokens. Callers authenticate with client credentials from
+// INTROSPECTION_CLIENTS either as Basic auth or client_id and client_secret
+// form values.
+func (cfg *apiConfig) handlerIntrospect(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	client_id, client_secret, ok := r.BasicAuth()
+	if !ok {
+		client_id, client_secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if !cfg.checkIntrospectionClient(client_id, client_secret) {
+		log.Printf("Introspection with invalid client credentials for client '%s'", client_id)
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	// why the token is not valid is not told to the caller
+	claims, scopes, err := cfg.validateAnyToken(r.Context(), token)
+	if err != nil {
+		log.Printf("Introspected token is not active: %s", err)
+		writeJSON(w, http.StatusOK, IntrospectionResponse{Active: false})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, IntrospectionResponse{
+		Active:    true,
+		Scope:     strings.Join(scopes, " "),
+		ClientID:  client_id,
+		TokenType: "Bearer",
+		Exp:       claims.ExpiresAt.Unix(),
+		Iat:       claims.IssuedAt.Unix(),
+		Sub:       claims.UserID.String(),
+		Iss:       auth.Issuer,
+		Jti:       claims.TokenID,
+	})
+}
+
+// checkIntrospectionClient compares hashes so the time taken does not depend
+// on how much of the secret matched or on its length
+func (cfg *apiConfig) checkIntrospectionClient(client_id, client_secret string) bool {
+	expected_secret, found := cfg.introspection_clients[client_id]
+	if !found || client_id == "" || client_secret == "" {
+		return false
+	}
+	expected_hash := sha256.Sum256([]byte(expected_secret))
+	given_hash := sha256.Sum256([]byte(client_secret))
+	return subtle.ConstantTimeCompare(expected_hash[:], given_hash[:]) == 1
+}
+
+// handlerUserInfo returns the user of the bearer token, personal access
+// tokens need user:read scope
+func (cfg *apiConfig) handlerUserInfo(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUserWithScope(w, r, scopeUserRead)
+	if !ok {
+		return
+	}
+
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		writeInvalidToken(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, User{
+		ID:            db_user.ID,
+		CreatedAt:     db_user.CreatedAt,
+		UpdatedAt:     db_user.UpdatedAt,
+		Email:         db_user.Email,
+		ChirpyRed:     db_user.IsChirpyRed,
+		EmailVerified: db_user.EmailVerifiedAt.Valid,
+		Role:          db_user.Role,
+	})
+}
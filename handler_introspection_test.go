@@ -0,0 +1,106 @@
+package main
+
+import (
+	"time"
+	"testing"
+	"net/url"
+	"strings"
+	"net/http"
+	"net/http/httptest"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+)
+
+func TestCheckIntrospectionClient(t *testing.T) {
+	cfg := &apiConfig{
+		introspection_clients: map[string]string{"gateway": "gateway-secret"},
+	}
+
+	tests := []struct {
+		name         string
+		clientID     string
+		clientSecret string
+		want         bool
+	}{
+		{"Valid client", "gateway", "gateway-secret", true},
+		{"Wrong secret", "gateway", "gateway-secre", false},
+		{"Secret with extra characters", "gateway", "gateway-secret2", false},
+		{"Unknown client", "other", "gateway-secret", false},
+		{"Empty secret", "gateway", "", false},
+		{"Empty client", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.checkIntrospectionClient(tt.clientID, tt.clientSecret); got != tt.want {
+				t.Errorf("checkIntrospectionClient() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// only JWTs are used here, personal access tokens would need the database
+func TestIntrospect(t *testing.T) {
+	keyring, err := auth.NewKeyring(map[string]string{"a": "secret"}, "a")
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	cfg := &apiConfig{
+		keyring:               keyring,
+		introspection_clients: map[string]string{"gateway": "gateway-secret"},
+	}
+	user_id := uuid.New()
+	valid_token, _ := keyring.MakeJWT(user_id, time.Hour)
+	other_keyring, _ := auth.NewKeyring(map[string]string{"a": "other-secret"}, "a")
+	foreign_token, _ := other_keyring.MakeJWT(user_id, time.Hour)
+
+	introspect := func(client_secret string, token string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}}
+		req := httptest.NewRequest("POST", "/api/oauth/introspect", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("gateway", client_secret)
+		recorder := httptest.NewRecorder()
+		cfg.handlerIntrospect(recorder, req)
+		return recorder
+	}
+
+	t.Run("Invalid client", func(t *testing.T) {
+		recorder := introspect("wrong", valid_token)
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Active token", func(t *testing.T) {
+		recorder := introspect("gateway-secret", valid_token)
+		response := IntrospectionResponse{}
+		json.NewDecoder(recorder.Body).Decode(&response)
+		if recorder.Code != http.StatusOK || !response.Active || response.Sub != user_id.String() {
+			t.Errorf("got status %d and %+v, want active token of %s", recorder.Code, response, user_id)
+		}
+	})
+
+	// inactive tokens get nothing but active false, not even the reason
+	inactive_tokens := []struct {
+		name  string
+		token string
+	}{
+		{"Malformed token", "not-a-token"},
+		{"Token signed with other key", foreign_token},
+	}
+	for _, tt := range inactive_tokens {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := introspect("gateway-secret", tt.token)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+			}
+			response := map[string]interface{}{}
+			json.NewDecoder(recorder.Body).Decode(&response)
+			if len(response) != 1 || response["active"] != false {
+				t.Errorf("response = %v, want only active false", response)
+			}
+		})
+	}
+}
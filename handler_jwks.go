@@ -0,0 +1,21 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"encoding/json"
+)
+
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	response_data, err := json.Marshal(cfg.keyring.JWKS())
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	// verifiers cache the keys, new signing key should be published at least this long before it's used
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response_data)
+}
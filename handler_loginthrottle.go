@@ -0,0 +1,282 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"strconv"
+	"strings"
+	"net/http"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+const (
+	throttleAccount = "account"
+	throttleIP      = "ip"
+)
+
+type LoginLockout struct {
+	ID          uuid.UUID  `json:"id"`
+	Kind        string     `json:"kind"`
+	Subject     string     `json:"subject"`
+	UserID      *uuid.UUID `json:"user_id"`
+	Failures    int32      `json:"failures"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LockedUntil time.Time  `json:"locked_until"`
+	UnlockedAt  *time.Time `json:"unlocked_at"`
+	UnlockedBy  *uuid.UUID `json:"unlocked_by"`
+}
+
+// loginAttempt is one login try counted as pending for the account and the
+// client IP until its result is recorded. No rows stay locked while the
+// password is hashed, the claim is a single statement.
+type loginAttempt struct {
+	email string
+	ip    string
+	done  bool
+}
+
+// beginLoginAttempt writes 429 when the account or the client IP is still
+// blocked by earlier failures or has too many attempts in progress. It is
+// checked before the password so the response does not tell if the guess was
+// right. Caller has to defer cfg.endLoginAttempt.
+func (cfg *apiConfig) beginLoginAttempt(w http.ResponseWriter, r *http.Request, email string) (*loginAttempt, bool) {
+	attempt := &loginAttempt{
+		email: strings.ToLower(email),
+		ip:    clientIP(r),
+	}
+
+	account_ok, err := cfg.claimLoginAttempt(r, throttleAccount, attempt.email, auth.AccountLoginPolicy)
+	ip_ok := false
+	if err == nil && account_ok {
+		ip_ok, err = cfg.claimLoginAttempt(r, throttleIP, attempt.ip, auth.IPLoginPolicy)
+		if err != nil || !ip_ok {
+			cfg.releaseLoginAttempt(r, throttleAccount, attempt.email)
+		}
+	}
+	if err != nil {
+		// failing open here would turn off the protection, failing closed
+		// would block all logins, 500 is the honest answer
+		log.Printf("Error checking login throttle: %s", err)
+		w.WriteHeader(500)
+		return nil, false
+	}
+	if ip_ok {
+		return attempt, true
+	}
+
+	retry_after, err := cfg.dbq.GetLoginRetryAfter(r.Context(), database.GetLoginRetryAfterParams{
+		Email: attempt.email,
+		Ip:    attempt.ip,
+	})
+	if err != nil || retry_after <= 0 {
+		// blocked only by another attempt in progress
+		retry_after = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry_after)))
+	writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "Too many failed login attempts, try again later"})
+	return nil, false
+}
+
+func (cfg *apiConfig) claimLoginAttempt(r *http.Request, kind, subject string, policy auth.LoginThrottlePolicy) (bool, error) {
+	_, err := cfg.dbq.ClaimLoginAttempt(r.Context(), database.ClaimLoginAttemptParams{
+		Kind:         kind,
+		Subject:      subject,
+		FreeAttempts: int32(policy.FreeAttempts),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (cfg *apiConfig) releaseLoginAttempt(r *http.Request, kind, subject string) {
+	err := cfg.dbq.ReleaseLoginAttempt(r.Context(), database.ReleaseLoginAttemptParams{
+		Kind:    kind,
+		Subject: subject,
+	})
+	if err != nil {
+		log.Printf("Error releasing login attempt: %s", err)
+	}
+}
+
+// endLoginAttempt releases the attempt when the handler returned without
+// recording a result, for example on errors
+func (cfg *apiConfig) endLoginAttempt(r *http.Request, attempt *loginAttempt) {
+	if attempt.done {
+		return
+	}
+	attempt.done = true
+	cfg.releaseLoginAttempt(r, throttleAccount, attempt.email)
+	cfg.releaseLoginAttempt(r, throttleIP, attempt.ip)
+}
+
+// loginFailed counts the failure for the email and the client IP and blocks
+// them when the policy says so. Unknown emails are counted as well so the
+// responses are the same for existing and missing accounts.
+func (cfg *apiConfig) loginFailed(r *http.Request, attempt *loginAttempt, user_id uuid.NullUUID) {
+	attempt.done = true
+	cfg.throttleLogin(r, throttleAccount, attempt.email, user_id, auth.AccountLoginPolicy)
+	cfg.throttleLogin(r, throttleIP, attempt.ip, uuid.NullUUID{}, auth.IPLoginPolicy)
+
+	err := cfg.dbq.DeleteStaleLoginThrottles(r.Context())
+	if err != nil {
+		log.Printf("Error deleting stale login throttles: %s", err)
+	}
+}
+
+func (cfg *apiConfig) throttleLogin(r *http.Request, kind, subject string, user_id uuid.NullUUID, policy auth.LoginThrottlePolicy) {
+	failures, err := cfg.dbq.RecordLoginFailure(r.Context(), database.RecordLoginFailureParams{
+		Kind:    kind,
+		Subject: subject,
+	})
+	if err != nil {
+		log.Printf("Error recording failed login: %s", err)
+		return
+	}
+
+	delay, lockout := policy.Block(int(failures))
+	if delay <= 0 {
+		return
+	}
+	err = cfg.dbq.BlockLogin(r.Context(), database.BlockLoginParams{
+		Kind:    kind,
+		Subject: subject,
+		Seconds: int32(delay.Seconds()),
+	})
+	if err != nil {
+		log.Printf("Error blocking login: %s", err)
+		return
+	}
+	if !lockout {
+		return
+	}
+
+	log.Printf("Login locked for %s %s after %d failures", kind, subject, failures)
+	err = cfg.dbq.CreateLoginLockout(r.Context(), database.CreateLoginLockoutParams{
+		Kind:     kind,
+		Subject:  subject,
+		UserID:   user_id,
+		Failures: failures,
+		Seconds:  int32(delay.Seconds()),
+	})
+	if err != nil {
+		log.Printf("Error recording login lockout: %s", err)
+	}
+}
+
+// loginSucceeded resets the account after successful check. The IP counter is
+// left alone, otherwise logging in to own account would reset the guesses
+// made against others.
+func (cfg *apiConfig) loginSucceeded(r *http.Request, attempt *loginAttempt) {
+	attempt.done = true
+	err := cfg.dbq.ClearLoginThrottle(r.Context(), database.ClearLoginThrottleParams{
+		Kind:    throttleAccount,
+		Subject: attempt.email,
+	})
+	if err != nil {
+		log.Printf("Error clearing login throttle: %s", err)
+	}
+	cfg.releaseLoginAttempt(r, throttleIP, attempt.ip)
+}
+
+func (cfg *apiConfig) handlerGetLoginLockouts(w http.ResponseWriter, r *http.Request) {
+	db_lockouts, err := cfg.dbq.GetLoginLockouts(r.Context())
+	if err != nil {
+		log.Printf("Error getting login lockouts: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	result_slice := make([]LoginLockout, 0, len(db_lockouts))
+	for _, db_lockout := range db_lockouts {
+		result_slice = append(result_slice, loginLockoutFromDB(db_lockout))
+	}
+	writeJSON(w, http.StatusOK, result_slice)
+}
+
+// handlerUnlockLogin removes the block of the locked account or IP and marks
+// its lockout events as unlocked
+func (cfg *apiConfig) handlerUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	lockout_id, err := uuid.Parse(r.PathValue("lockoutID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	db_lockout, err := cfg.dbq.GetLoginLockout(r.Context(), lockout_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting login lockout: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbq.WithTx(tx)
+
+	err = qtx.ClearLoginThrottle(r.Context(), database.ClearLoginThrottleParams{
+		Kind:    db_lockout.Kind,
+		Subject: db_lockout.Subject,
+	})
+	if err != nil {
+		log.Printf("Error clearing login throttle: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = qtx.UnlockLoginLockouts(r.Context(), database.UnlockLoginLockoutsParams{
+		Kind:       db_lockout.Kind,
+		Subject:    db_lockout.Subject,
+		UnlockedBy: uuid.NullUUID{UUID: userIDFromContext(r), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error unlocking login lockouts: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Error committing unlock: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	log.Printf("Admin %s unlocked login for %s %s", userIDFromContext(r), db_lockout.Kind, db_lockout.Subject)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func loginLockoutFromDB(db_lockout database.LoginLockout) LoginLockout {
+	result := LoginLockout{
+		ID:          db_lockout.ID,
+		Kind:        db_lockout.Kind,
+		Subject:     db_lockout.Subject,
+		Failures:    db_lockout.Failures,
+		CreatedAt:   db_lockout.CreatedAt,
+		LockedUntil: db_lockout.LockedUntil,
+	}
+	if db_lockout.UserID.Valid {
+		result.UserID = &db_lockout.UserID.UUID
+	}
+	if db_lockout.UnlockedAt.Valid {
+		result.UnlockedAt = &db_lockout.UnlockedAt.Time
+	}
+	if db_lockout.UnlockedBy.Valid {
+		result.UnlockedBy = &db_lockout.UnlockedBy.UUID
+	}
+	return result
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"net/http"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+const (
+	mfaChallengeLifetime = 5 * time.Minute
+	// after this many wrong codes in a row every new wrong code revokes the
+	// challenge and the password is needed again, the count is kept across
+	// challenges until a correct code
+	mfaMaxFailedAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+func (cfg *apiConfig) writeMFAChallenge(w http.ResponseWriter, user_id uuid.UUID) {
+	challenge_token, err := cfg.keyring.MakeMFAChallengeToken(user_id, mfaChallengeLifetime)
+	if err != nil {
+		log.Printf("Failed to generate MFA challenge token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type mfaChallenge struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+	writeJSON(w, http.StatusOK, mfaChallenge{
+		MFARequired: true,
+		MFAToken:    challenge_token,
+	})
+}
+
+// handlerEnrollTOTP creates new secret that is not used for logins until it's
+// confirmed with a code from the authenticator app
+func (cfg *apiConfig) handlerEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+	db_totp, err := cfg.dbq.GetUserTOTP(r.Context(), user_id)
+	if err == nil && db_totp.ConfirmedAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("2FA is already enabled"))
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("Failed to generate TOTP secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.dbq.UpsertPendingTOTP(r.Context(), database.UpsertPendingTOTPParams{
+		UserID: user_id,
+		Secret: secret,
+	})
+	if err != nil {
+		log.Printf("Error storing TOTP secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type enrollment struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+	writeJSON(w, http.StatusCreated, enrollment{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI("Chirpy", db_user.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) handlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	type RequestedConfirm struct {
+		Code string `json:"code"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_confirm := RequestedConfirm{}
+	err := decoder.Decode(&requested_confirm)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	db_totp, err := cfg.dbq.GetUserTOTP(r.Context(), user_id)
+	if err != nil || db_totp.ConfirmedAt.Valid {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte("No pending 2FA enrollment"))
+		return
+	}
+	step, valid := auth.ValidateTOTP(db_totp.Secret, requested_confirm.Code, time.Now())
+	if !valid {
+		w.WriteHeader(401)
+		w.Write([]byte("Invalid code"))
+		return
+	}
+
+	recovery_codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Printf("Failed to generate recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Failed to start transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbq.WithTx(tx)
+
+	confirmed_count, err := qtx.ConfirmTOTP(r.Context(), database.ConfirmTOTPParams{
+		UserID:       user_id,
+		LastUsedStep: step,
+	})
+	if err != nil || confirmed_count == 0 {
+		log.Printf("Error confirming TOTP: %v", err)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	err = qtx.DeleteUserRecoveryCodes(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error deleting recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	for _, recovery_code := range recovery_codes {
+		err = qtx.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+			CodeHash: auth.HashRecoveryCode(recovery_code),
+			UserID:   user_id,
+		})
+		if err != nil {
+			log.Printf("Error storing recovery code: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Failed to commit TOTP confirmation: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// recovery codes are only stored hashed, this is the only time they are shown
+	type confirmation struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	writeJSON(w, http.StatusOK, confirmation{RecoveryCodes: recovery_codes})
+}
+
+// handlerDisableTOTP needs the password and a current code or recovery code,
+// a stolen access token with guessed password is not enough. Wrong answers
+// count as failed logins.
+func (cfg *apiConfig) handlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	type RequestedDisable struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_disable := RequestedDisable{}
+	err := decoder.Decode(&requested_disable)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+	attempt, ok := cfg.beginLoginAttempt(w, r, db_user.Email)
+	if !ok {
+		return
+	}
+	defer cfg.endLoginAttempt(r, attempt)
+
+	err = auth.CheckPasswordHash(db_user.HashedPassword, requested_disable.Password)
+	if err != nil {
+		cfg.loginFailed(r, attempt, uuid.NullUUID{UUID: user_id, Valid: true})
+		w.WriteHeader(401)
+		w.Write([]byte("Incorrect password"))
+		return
+	}
+
+	// pending enrollment can be removed with the password only
+	db_totp, err := cfg.dbq.GetUserTOTP(r.Context(), user_id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting 2FA status: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err == nil && db_totp.ConfirmedAt.Valid {
+		verified, err := cfg.verifySecondFactor(r, db_totp, requested_disable.Code, requested_disable.RecoveryCode)
+		if err != nil {
+			log.Printf("Error checking second factor: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if !verified {
+			cfg.loginFailed(r, attempt, uuid.NullUUID{UUID: user_id, Valid: true})
+			w.WriteHeader(401)
+			w.Write([]byte("Invalid code"))
+			return
+		}
+	}
+	cfg.loginSucceeded(r, attempt)
+
+	err = cfg.dbq.DeleteUserTOTP(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error deleting TOTP: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.dbq.DeleteUserRecoveryCodes(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error deleting recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLoginMFA is the second step of login for users with 2FA, the challenge
+// token from /api/login is exchanged for access and refresh token with either
+// TOTP code or one of the recovery codes
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type RequestedMFA struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_mfa := RequestedMFA{}
+	err := decoder.Decode(&requested_mfa)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	claims, err := cfg.keyring.ValidateMFAChallengeToken(requested_mfa.MFAToken)
+	if err != nil {
+		log.Printf("Invalid MFA challenge token: %s", err)
+		w.WriteHeader(401)
+		w.Write([]byte("Invalid or expired MFA token"))
+		return
+	}
+	db_totp, err := cfg.dbq.GetUserTOTP(r.Context(), claims.UserID)
+	if err != nil || !db_totp.ConfirmedAt.Valid {
+		log.Printf("MFA login for user %s without 2FA: %v", claims.UserID, err)
+		w.WriteHeader(401)
+		return
+	}
+	db_user, err := cfg.dbq.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// wrong codes count against the account like wrong passwords
+	attempt, ok := cfg.beginLoginAttempt(w, r, db_user.Email)
+	if !ok {
+		return
+	}
+	defer cfg.endLoginAttempt(r, attempt)
+
+	verified, err := cfg.verifySecondFactor(r, db_totp, requested_mfa.Code, requested_mfa.RecoveryCode)
+	if err != nil {
+		log.Printf("Error checking second factor: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !verified {
+		cfg.loginFailed(r, attempt, uuid.NullUUID{UUID: db_user.ID, Valid: true})
+		failed_attempts, err := cfg.dbq.IncrementTOTPFailedAttempts(r.Context(), claims.UserID)
+		if err != nil {
+			// without the count the limit can't be checked, the challenge is revoked to be safe
+			log.Printf("Error counting failed MFA attempts: %s", err)
+			failed_attempts = mfaMaxFailedAttempts
+		}
+		if failed_attempts >= mfaMaxFailedAttempts {
+			log.Printf("Too many failed MFA attempts for user %s, revoking challenge", claims.UserID)
+			_, err = cfg.revokeToken(r, claims)
+			if err != nil {
+				log.Printf("Error revoking token: %s", err)
+			}
+		}
+		w.WriteHeader(401)
+		w.Write([]byte("Invalid code"))
+		return
+	}
+	cfg.loginSucceeded(r, attempt)
+
+	// challenge token can only be used once, revoking fails when another request already used it
+	first_use, err := cfg.revokeToken(r, claims)
+	if err != nil {
+		log.Printf("Error revoking token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !first_use {
+		w.WriteHeader(401)
+		w.Write([]byte("Invalid or expired MFA token"))
+		return
+	}
+
+	cfg.writeLoginResponse(w, r, db_user, 3600*time.Second)
+}
+
+func (cfg *apiConfig) verifySecondFactor(r *http.Request, db_totp database.UserTotp, code, recovery_code string) (bool, error) {
+	if code != "" {
+		step, valid := auth.ValidateTOTP(db_totp.Secret, code, time.Now())
+		if !valid {
+			return false, nil
+		}
+		used_count, err := cfg.dbq.UseTOTPStep(r.Context(), database.UseTOTPStepParams{
+			UserID:       db_totp.UserID,
+			LastUsedStep: step,
+		})
+		return used_count == 1, err
+	}
+	if recovery_code != "" {
+		used_count, err := cfg.dbq.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:   db_totp.UserID,
+			CodeHash: auth.HashRecoveryCode(recovery_code),
+		})
+		if err != nil || used_count == 0 {
+			return false, err
+		}
+		err = cfg.dbq.ResetTOTPFailedAttempts(r.Context(), db_totp.UserID)
+		return err == nil, err
+	}
+	return false, nil
+}
+
+// revokeToken adds the token to the jti denylist, false when it was already revoked
+func (cfg *apiConfig) revokeToken(r *http.Request, claims auth.TokenClaims) (bool, error) {
+	revoked_count, err := cfg.dbq.RevokeAccessToken(r.Context(), database.RevokeAccessTokenParams{
+		Jti:       claims.TokenID,
+		UserID:    claims.UserID,
+		ExpiresAt: claims.ExpiresAt,
+	})
+	if err != nil {
+		return false, err
+	}
+	cfg.denylist.TokenRevoked(claims)
+	return revoked_count == 1, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"strings"
+	"net/http"
+	"database/sql"
+	"crypto/subtle"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+	"github.com/t6kke/chirpy/internal/mailer"
+	"github.com/t6kke/chirpy/internal/oidc"
+)
+
+const oidcStateCookie = "chirpy_oidc_state"
+
+// handlerOIDCLogin starts the login by sending the browser to the provider.
+// State is also kept in a cookie so the callback only works in the browser
+// that started the login.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.GenerateRandom()
+	if err != nil {
+		log.Printf("Failed to generate OIDC state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	nonce, err := oidc.GenerateRandom()
+	if err != nil {
+		log.Printf("Failed to generate OIDC nonce: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	code_verifier, err := oidc.GenerateRandom()
+	if err != nil {
+		log.Printf("Failed to generate PKCE verifier: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	auth_url, err := cfg.oidc_client.AuthCodeURL(r.Context(), state, nonce, code_verifier)
+	if err != nil {
+		log.Printf("Failed to build OIDC authorization URL: %s", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	err = cfg.dbq.CreateOIDCLoginState(r.Context(), database.CreateOIDCLoginStateParams{
+		StateHash:    auth.HashOpaqueToken(state),
+		Nonce:        nonce,
+		CodeVerifier: code_verifier,
+	})
+	if err != nil {
+		log.Printf("Failed to store OIDC state: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.dbq.DeleteExpiredOIDCLoginStates(r.Context())
+	if err != nil {
+		log.Printf("Error deleting expired OIDC states: %s", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.oidc_redirect_url, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, auth_url, http.StatusFound)
+}
+
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		log.Printf("OIDC provider returned error: %s %s", query.Get("error"), query.Get("error_description"))
+		w.WriteHeader(401)
+		w.Write([]byte("Login with identity provider failed"))
+		return
+	}
+
+	state := query.Get("state")
+	state_cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(state_cookie.Value), []byte(state)) != 1 {
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid login state"))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth/oidc", MaxAge: -1})
+
+	db_state, err := cfg.dbq.UseOIDCLoginState(r.Context(), auth.HashOpaqueToken(state))
+	if err != nil {
+		log.Printf("No valid OIDC state found: %s", err)
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid or expired login state"))
+		return
+	}
+
+	raw_id_token, err := cfg.oidc_client.Exchange(r.Context(), query.Get("code"), db_state.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %s", err)
+		w.WriteHeader(401)
+		w.Write([]byte("Login with identity provider failed"))
+		return
+	}
+	id_token, err := cfg.oidc_client.VerifyIDToken(r.Context(), raw_id_token, db_state.Nonce)
+	if err != nil {
+		log.Printf("Invalid ID token: %s", err)
+		w.WriteHeader(401)
+		w.Write([]byte("Login with identity provider failed"))
+		return
+	}
+
+	db_user, status, err := cfg.userFromIdentity(r, id_token)
+	if err != nil {
+		log.Printf("Failed to find user for identity %s: %s", id_token.Subject, err)
+		w.WriteHeader(status)
+		if status != 500 {
+			w.Write([]byte(err.Error()))
+		}
+		return
+	}
+
+	db_totp, err := cfg.dbq.GetUserTOTP(r.Context(), db_user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting 2FA status: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err == nil && db_totp.ConfirmedAt.Valid {
+		cfg.writeMFAChallenge(w, db_user.ID)
+		return
+	}
+	cfg.writeLoginResponse(w, r, db_user, 3600*time.Second)
+}
+
+// userFromIdentity finds the user linked to the provider account. Unknown
+// accounts are linked to existing user by email only when both the provider
+// and chirpy have verified it, otherwise someone could register the email
+// here first and get access to the account. Without existing user new one
+// is created without password.
+func (cfg *apiConfig) userFromIdentity(r *http.Request, id_token oidc.IDToken) (database.User, int, error) {
+	user_id, err := cfg.dbq.GetUserIDByIdentity(r.Context(), database.GetUserIDByIdentityParams{
+		Issuer:  id_token.Issuer,
+		Subject: id_token.Subject,
+	})
+	if err == nil {
+		db_user, err := cfg.dbq.GetUserByID(r.Context(), user_id)
+		return db_user, 500, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, 500, err
+	}
+
+	err = mailer.ValidateAddress(id_token.Email)
+	if err != nil {
+		return database.User{}, 400, errors.New("identity provider did not give valid email")
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		return database.User{}, 500, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbq.WithTx(tx)
+
+	db_user, err := qtx.FindUserWithEmail(r.Context(), id_token.Email)
+	if err == nil {
+		if !id_token.EmailVerified || !db_user.EmailVerifiedAt.Valid {
+			return database.User{}, 409, errors.New("account with this email already exists, it is linked once the email is verified both in chirpy and at the identity provider")
+		}
+	} else if errors.Is(err, sql.ErrNoRows) {
+		// empty hash never matches a password, password reset can set one later
+		db_user, err = qtx.CreateUser(r.Context(), database.CreateUserParams{
+			Email:          id_token.Email,
+			HashedPassword: "",
+		})
+		if err != nil {
+			return database.User{}, 500, err
+		}
+		if id_token.EmailVerified {
+			db_user, err = qtx.MarkEmailVerified(r.Context(), database.MarkEmailVerifiedParams{
+				ID:    db_user.ID,
+				Email: db_user.Email,
+			})
+			if err != nil {
+				return database.User{}, 500, err
+			}
+		}
+	} else {
+		return database.User{}, 500, err
+	}
+
+	err = qtx.CreateUserIdentity(r.Context(), database.CreateUserIdentityParams{
+		Issuer:  id_token.Issuer,
+		Subject: id_token.Subject,
+		UserID:  db_user.ID,
+	})
+	if err != nil {
+		return database.User{}, 500, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return database.User{}, 500, err
+	}
+
+	if !db_user.EmailVerifiedAt.Valid {
+		err = cfg.sendEmailVerification(r, db_user.ID, db_user.Email)
+		if err != nil {
+			log.Printf("Error creating verification token: %s", err)
+		}
+	}
+	return db_user, 200, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"context"
+	"net/http"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+	"github.com/t6kke/chirpy/internal/mailer"
+)
+
+// handlerPasswordResetRequest always answers 202 so it can't be used to find
+// out which emails have an account, the mail is sent in the background for
+// the same reason
+func (cfg *apiConfig) handlerPasswordResetRequest(w http.ResponseWriter, r *http.Request) {
+	type RequestedReset struct {
+		Email string `json:"email"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_reset := RequestedReset{}
+	err := decoder.Decode(&requested_reset)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	db_user, err := cfg.dbq.FindUserWithEmail(r.Context(), requested_reset.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Password reset requested for unknown email")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		log.Printf("Error finding user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	reset_token, err := auth.MakeOpaqueToken()
+	if err != nil {
+		log.Printf("Failed to generate reset token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.dbq.CreatePasswordResetToken(r.Context(), database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashOpaqueToken(reset_token),
+		UserID:    db_user.ID,
+	})
+	if err != nil {
+		log.Printf("Failed to insert reset token to DB: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	message := mailer.Message{
+		To:      db_user.Email,
+		Subject: "Reset your Chirpy password",
+		Body:    "Someone asked to reset the password of your Chirpy account. If it was you, use this token within one hour to set a new password:\n\n" +
+			reset_token + "\n\nIf you did not ask for it you can ignore this email.\n",
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		err := cfg.mailer.Send(ctx, message)
+		if err != nil {
+			log.Printf("Failed to send password reset email to user %s: %s", db_user.ID, err)
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerPasswordResetConfirm sets the new password, the reset token can be
+// used once and every session of the user is logged out
+func (cfg *apiConfig) handlerPasswordResetConfirm(w http.ResponseWriter, r *http.Request) {
+	type RequestedConfirm struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_confirm := RequestedConfirm{}
+	err := decoder.Decode(&requested_confirm)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if requested_confirm.Token == "" || requested_confirm.Password == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("token and password are required"))
+		return
+	}
+	if !cfg.checkPassword(w, r, requested_confirm.Password) {
+		return
+	}
+
+	hashed_password, err := auth.HashPassword(requested_confirm.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Failed to start transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbq.WithTx(tx)
+
+	user_id, err := qtx.UsePasswordResetToken(r.Context(), auth.HashOpaqueToken(requested_confirm.Token))
+	if err != nil {
+		log.Printf("No valid reset token found: %s", err)
+		w.WriteHeader(401)
+		w.Write([]byte("Invalid or expired reset token"))
+		return
+	}
+
+	err = qtx.UpdatePassword(r.Context(), database.UpdatePasswordParams{
+		ID:             user_id,
+		HashedPassword: hashed_password,
+	})
+	if err != nil {
+		log.Printf("Error updating password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = qtx.DeleteUserPasswordResetTokens(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error deleting reset tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = qtx.RevokeAllUserRefreshTokens(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Failed to commit password reset: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	cfg.denylist.UserTokensRevoked(user_id)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"errors"
+	"net/http"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+// handlerSetUserRole is behind middlewareRequireRole(RoleAdmin)
+func (cfg *apiConfig) handlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	user_id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	type RequestedRole struct {
+		Role string `json:"role"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_role := RequestedRole{}
+	err = decoder.Decode(&requested_role)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+	if _, found := roleRanks[requested_role.Role]; !found {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Role must be one of user, moderator or admin"})
+		return
+	}
+	// admins can't lock themselves out by accident, another admin has to do it
+	if user_id == userIDFromContext(r) && requested_role.Role != RoleAdmin {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Admins can't remove their own admin role"})
+		return
+	}
+
+	db_user, err := cfg.dbq.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   user_id,
+		Role: requested_role.Role,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting user role: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	log.Printf("Admin %s set role of user %s to %s", userIDFromContext(r), db_user.ID, db_user.Role)
+
+	writeJSON(w, http.StatusOK, User{
+		ID:            db_user.ID,
+		CreatedAt:     db_user.CreatedAt,
+		UpdatedAt:     db_user.UpdatedAt,
+		Email:         db_user.Email,
+		ChirpyRed:     db_user.IsChirpyRed,
+		EmailVerified: db_user.EmailVerifiedAt.Valid,
+		Role:          db_user.Role,
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/google/uuid"
+)
+
+func TestHasRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		userRole string
+		role     string
+		want     bool
+	}{
+		{"Same role", RoleModerator, RoleModerator, true},
+		{"Admin as moderator", RoleAdmin, RoleModerator, true},
+		{"Admin as user", RoleAdmin, RoleUser, true},
+		{"Moderator as admin", RoleModerator, RoleAdmin, false},
+		{"User as moderator", RoleUser, RoleModerator, false},
+		{"Unknown role as user", "superuser", RoleUser, true},
+		{"Unknown role as admin", "superuser", RoleAdmin, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasRole(tt.userRole, tt.role); got != tt.want {
+				t.Errorf("hasRole(%q, %q) = %v, want %v", tt.userRole, tt.role, got, tt.want)
+			}
+		})
+	}
+}
+
+// the requests are rejected before the database is used
+func TestSetUserRoleValidation(t *testing.T) {
+	cfg := &apiConfig{}
+	admin_id := uuid.New()
+
+	tests := []struct {
+		name   string
+		userID uuid.UUID
+		body   string
+	}{
+		{"Unknown role", uuid.New(), `{"role": "superuser"}`},
+		{"Admin demoting themselves", admin_id, `{"role": "moderator"}`},
+		{"Admin removing all roles from themselves", admin_id, `{"role": "user"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("PUT", "/admin/users/"+tt.userID.String()+"/role", strings.NewReader(tt.body))
+			req.SetPathValue("userID", tt.userID.String())
+			req = req.WithContext(context.WithValue(req.Context(), contextKeyUserID, admin_id))
+			recorder := httptest.NewRecorder()
+			cfg.handlerSetUserRole(recorder, req)
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
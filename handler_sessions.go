@@ -0,0 +1,147 @@
+package main
+
+import (
+	"log"
+	"time"
+	"net/http"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+func (cfg *apiConfig) handlerGetSessions(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	db_sessions, err := cfg.dbq.GetUserSessions(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error getting sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	result_slice := make([]Session, 0, len(db_sessions))
+	for _, db_session := range db_sessions {
+		result_slice = append(result_slice, sessionFromRefreshToken(db_session))
+	}
+
+	response_data, err := json.Marshal(result_slice)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(response_data)
+}
+
+func (cfg *apiConfig) handlerDeleteSession(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	session_id, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	revoked_count, err := cfg.dbq.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		UserID:   user_id,
+		FamilyID: session_id,
+	})
+	if err != nil {
+		log.Printf("Error revoking session: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if revoked_count == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLogout revokes the access token used for the request, the refresh
+// token is revoked separately with /api/revoke
+func (cfg *apiConfig) handlerLogout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := cfg.authenticateToken(w, r)
+	if !ok {
+		return
+	}
+	if claims.TokenID == "" {
+		log.Printf("Token of user %s has no jti, can't revoke it", claims.UserID)
+		w.WriteHeader(400)
+		w.Write([]byte("Token can't be revoked, use /api/logout-all"))
+		return
+	}
+
+	_, err := cfg.revokeToken(r, claims)
+	if err != nil {
+		log.Printf("Error revoking access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// the denylist only needs tokens that haven't expired yet
+	err = cfg.dbq.DeleteExpiredRevokedAccessTokens(r.Context())
+	if err != nil {
+		log.Printf("Error deleting expired revoked access tokens: %s", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerLogoutAll(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.dbq.RevokeAllUserRefreshTokens(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = cfg.dbq.RevokeAllUserAccessTokens(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error revoking access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	cfg.denylist.UserTokensRevoked(user_id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sessions are refresh token families, the family ID stays the same through rotations
+func sessionFromRefreshToken(db_refresh_token database.RefreshToken) Session {
+	return Session{
+		ID:         db_refresh_token.FamilyID,
+		UserAgent:  db_refresh_token.UserAgent,
+		IP:         db_refresh_token.Ip,
+		LastUsedAt: db_refresh_token.LastUsedAt,
+		ExpiresAt:  db_refresh_token.ExpiresAt,
+	}
+}
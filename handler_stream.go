@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"net/http"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/database"
+	"github.com/t6kke/chirpy/internal/pubsub"
+)
+
+const streamHeartbeatInterval = 30 * time.Second
+
+func (cfg *apiConfig) handlerStreamChirps(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Printf("Streaming not supported by response writer")
+		w.WriteHeader(500)
+		return
+	}
+
+	// the stream is public, the hub also carries account events that are only
+	// for the user themselves
+	filter := func(event pubsub.Event) bool {
+		return event.Type == pubsub.EventChirpCreated || event.Type == pubsub.EventChirpDeleted
+	}
+	author_id_parameter := r.URL.Query().Get("author_id")
+	if author_id_parameter != "" {
+		author_uuid, err := uuid.Parse(author_id_parameter)
+		if err != nil {
+			log.Printf("Error decoding parameters: %s", err)
+			w.WriteHeader(400)
+			return
+		}
+		chirp_filter := filter
+		filter = func(event pubsub.Event) bool {
+			return chirp_filter(event) && event.UserID == author_uuid
+		}
+	}
+
+	// a client coming back after a dropped connection gets what it missed,
+	// or a resync event when the ID is from another instance or too old
+	var subscription *pubsub.Subscription
+	var missed_events []pubsub.Event
+	last_event_id := r.Header.Get("Last-Event-ID")
+	if last_event_id != "" {
+		subscription, missed_events = cfg.hub.Resume(filter, last_event_id)
+	} else {
+		subscription, missed_events = cfg.hub.Subscribe(filter, 0)
+	}
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for _, event := range missed_events {
+		cfg.writeSSEEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-subscription.C:
+			if !open {
+				log.Printf("Stream subscriber dropped for falling behind")
+				return
+			}
+			cfg.writeSSEEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func (cfg *apiConfig) writeSSEEvent(w http.ResponseWriter, event pubsub.Event) {
+	fmt.Fprintf(w, "id: %s\n", cfg.hub.EventID(event))
+	fmt.Fprintf(w, "event: %s\n", event.Type)
+	fmt.Fprintf(w, "data: %s\n\n", event.Data)
+}
+
+func (cfg *apiConfig) publishChirpEvent(event_type pubsub.EventType, db_chirp database.Chirp) {
+	response_chirp := Chirp{
+		ID:        db_chirp.ID,
+		CreatedAt: db_chirp.CreatedAt,
+		UpdatedAt: db_chirp.UpdatedAt,
+		Body:      db_chirp.Body,
+		UserID:    db_chirp.UserID,
+	}
+	event_data, err := json.Marshal(response_chirp)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		return
+	}
+
+	cfg.publisher.Publish(pubsub.Event{
+		Type:    event_type,
+		ChirpID: db_chirp.ID,
+		UserID:  db_chirp.UserID,
+		Data:    event_data,
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"log"
+	"time"
+	"slices"
+	"net/http"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+// scopes of personal access tokens, routes that accept the tokens check one
+// of these with authenticateUserWithScope
+const (
+	scopeChirpsRead  = "chirps:read"
+	scopeChirpsWrite = "chirps:write"
+	scopeUserRead    = "user:read"
+)
+
+var knownScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeUserRead}
+
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+// handlerCreateToken only accepts JWT so a leaked personal access token can't
+// be used to create more of them
+func (cfg *apiConfig) handlerCreateToken(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	type RequestedToken struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	requested_token := RequestedToken{}
+	err := decoder.Decode(&requested_token)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	if requested_token.Name == "" || len(requested_token.Name) > 100 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Name must be 1 to 100 characters"})
+		return
+	}
+	if len(requested_token.Scopes) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range requested_token.Scopes {
+		if !slices.Contains(knownScopes, scope) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Unknown scope " + scope})
+			return
+		}
+	}
+	if requested_token.ExpiresInDays < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expires_in_days can't be negative"})
+		return
+	}
+	scopes := slices.Compact(slices.Sorted(slices.Values(requested_token.Scopes)))
+
+	// 0 days means the token does not expire
+	expires_at := sql.NullTime{}
+	if requested_token.ExpiresInDays > 0 {
+		expires_at = sql.NullTime{Time: time.Now().AddDate(0, 0, requested_token.ExpiresInDays), Valid: true}
+	}
+
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		log.Printf("Failed to generate personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	db_token, err := cfg.dbq.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    user_id,
+		Name:      requested_token.Name,
+		TokenHash: auth.HashOpaqueToken(token),
+		Scopes:    scopes,
+		ExpiresAt: expires_at,
+	})
+	if err != nil {
+		log.Printf("Error creating personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// the token is stored hashed, this is the only time it's shown
+	response_token := personalAccessTokenFromDB(db_token)
+	response_token.Token = token
+	writeJSON(w, http.StatusCreated, response_token)
+}
+
+func (cfg *apiConfig) handlerGetTokens(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	db_tokens, err := cfg.dbq.GetUserPersonalAccessTokens(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error getting personal access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	result_slice := make([]PersonalAccessToken, 0, len(db_tokens))
+	for _, db_token := range db_tokens {
+		result_slice = append(result_slice, personalAccessTokenFromDB(db_token))
+	}
+	writeJSON(w, http.StatusOK, result_slice)
+}
+
+func (cfg *apiConfig) handlerDeleteToken(w http.ResponseWriter, r *http.Request) {
+	user_id, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+
+	token_id, err := uuid.Parse(r.PathValue("tokenID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	revoked_count, err := cfg.dbq.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     token_id,
+		UserID: user_id,
+	})
+	if err != nil {
+		log.Printf("Error revoking personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if revoked_count == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func personalAccessTokenFromDB(db_token database.PersonalAccessToken) PersonalAccessToken {
+	result := PersonalAccessToken{
+		ID:        db_token.ID,
+		Name:      db_token.Name,
+		Scopes:    db_token.Scopes,
+		CreatedAt: db_token.CreatedAt,
+	}
+	if db_token.LastUsedAt.Valid {
+		result.LastUsedAt = &db_token.LastUsedAt.Time
+	}
+	if db_token.ExpiresAt.Valid {
+		result.ExpiresAt = &db_token.ExpiresAt.Time
+	}
+	return result
+}
@@ -3,23 +3,29 @@ package main
 import (
 	"log"
 	"time"
+	"errors"
 	"net/http"
+	"database/sql"
 	"encoding/json"
 
 	"github.com/google/uuid"
 
 	"github.com/t6kke/chirpy/internal/database"
 	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/mailer"
+	"github.com/t6kke/chirpy/internal/passwordpolicy"
 )
 
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	ChirpyRed    bool      `json:"is_chirpy_red"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	ChirpyRed     bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
 }
 
 func (cfg *apiConfig) handlerAddUser(w http.ResponseWriter, r *http.Request) {
@@ -37,6 +43,15 @@ func (cfg *apiConfig) handlerAddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = mailer.ValidateAddress(new_user_req.Email)
+	if err != nil {
+		writeInvalidEmail(w, err)
+		return
+	}
+	if !cfg.checkPassword(w, r, new_user_req.Password) {
+		return
+	}
+
 	hashed_password, err := auth.HashPassword(new_user_req.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %s", err)
@@ -56,12 +71,19 @@ func (cfg *apiConfig) handlerAddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = cfg.sendEmailVerification(r, db_user.ID, db_user.Email)
+	if err != nil {
+		log.Printf("Error creating verification token: %s", err)
+	}
+
 	response_user := User{
 		ID:        db_user.ID,
 		CreatedAt: db_user.CreatedAt,
 		UpdatedAt: db_user.UpdatedAt,
 		Email:     db_user.Email,
 		ChirpyRed: db_user.IsChirpyRed,
+		EmailVerified: db_user.EmailVerifiedAt.Valid,
+		Role:      db_user.Role,
 	}
 	response_data, err := json.Marshal(response_user)
 	if err != nil {
@@ -96,22 +118,74 @@ func (cfg *apiConfig) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 		new_user_req.ExpiresInSec = new_user_req.ExpiresInSec * time.Second
 	}
 
+	attempt, ok := cfg.beginLoginAttempt(w, r, new_user_req.Email)
+	if !ok {
+		return
+	}
+	defer cfg.endLoginAttempt(r, attempt)
+
 	db_user, err := cfg.dbq.FindUserWithEmail(r.Context(), new_user_req.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("failed to log in: no user with email %s", new_user_req.Email)
+		cfg.loginFailed(r, attempt, uuid.NullUUID{})
+		w.WriteHeader(401)
+		w.Write([]byte("Incorrect email or password"))
+		return
+	}
 	if err != nil {
-		log.Printf("Error creating user: %s", err)
-		w.WriteHeader(500) //TODO need better response to return info that user already exists
+		log.Printf("Error finding user: %s", err)
+		w.WriteHeader(500)
 		return
 	}
 
 	err = auth.CheckPasswordHash(db_user.HashedPassword, new_user_req.Password)
 	if err != nil {
 		log.Printf("failed to log in: %s", err)
+		cfg.loginFailed(r, attempt, uuid.NullUUID{UUID: db_user.ID, Valid: true})
 		w.WriteHeader(401)
 		w.Write([]byte("Incorrect email or password"))
 		return
 	}
 
-	token, err := auth.MakeJWT(db_user.ID, cfg.c_secret, new_user_req.ExpiresInSec)
+	// bcrypt hashes and argon2id with old parameters are upgraded while the
+	// password is at hand, failing this does not stop the login
+	if auth.PasswordNeedsRehash(db_user.HashedPassword) {
+		new_hash, err := auth.HashPassword(new_user_req.Password)
+		if err == nil {
+			err = cfg.dbq.RehashPassword(r.Context(), database.RehashPasswordParams{
+				ID:      db_user.ID,
+				NewHash: new_hash,
+				OldHash: db_user.HashedPassword,
+			})
+		}
+		if err != nil {
+			log.Printf("Error rehashing password of user %s: %s", db_user.ID, err)
+		}
+	}
+
+	// with 2FA the tokens are only given after the code is checked in /api/login/mfa
+	db_totp, err := cfg.dbq.GetUserTOTP(r.Context(), db_user.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting 2FA status: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if err == nil && db_totp.ConfirmedAt.Valid {
+		// failures are cleared only after the code in /api/login/mfa, otherwise
+		// knowing the password would reset the counter before every code guess
+		cfg.endLoginAttempt(r, attempt)
+		cfg.writeMFAChallenge(w, db_user.ID)
+		return
+	}
+	cfg.loginSucceeded(r, attempt)
+
+	cfg.writeLoginResponse(w, r, db_user, new_user_req.ExpiresInSec)
+}
+
+// writeLoginResponse creates new session for the user and responds with the
+// access and refresh tokens
+func (cfg *apiConfig) writeLoginResponse(w http.ResponseWriter, r *http.Request, db_user database.User, expires_in time.Duration) {
+	token, err := cfg.keyring.MakeJWT(db_user.ID, expires_in)
 	if err != nil {
 		log.Printf("Failed to generate token: %s", err)
 		w.WriteHeader(500)
@@ -125,9 +199,12 @@ func (cfg *apiConfig) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	r_token_insert_param := database.CreateRefreshTokenParams{
-		Token:     refresh_token,
+		TokenHash: auth.HashRefreshToken(refresh_token),
 		ExpiresAt: time.Now().AddDate(0, 0, 60),
 		UserID:    db_user.ID,
+		FamilyID:  uuid.New(),
+		UserAgent: r.UserAgent(),
+		Ip:        clientIP(r),
 	}
 
 	_, err = cfg.dbq.CreateRefreshToken(r.Context(), r_token_insert_param)
@@ -143,6 +220,8 @@ func (cfg *apiConfig) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt:    db_user.UpdatedAt,
 		Email:        db_user.Email,
 		ChirpyRed: db_user.IsChirpyRed,
+		EmailVerified: db_user.EmailVerifiedAt.Valid,
+		Role:      db_user.Role,
 		Token:        token,
 		RefreshToken: refresh_token,
 	}
@@ -160,21 +239,60 @@ func (cfg *apiConfig) handlerUserLogin(w http.ResponseWriter, r *http.Request) {
 func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request) {
 	refresh_token_from_header, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Printf("Failed to extract refresh token from header: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Failed to extract refresh token from header"))
+		writeAuthorizationError(w, err)
+		return
+	}
+
+	new_refresh_token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Failed to generate refresh token: %s", err)
+		w.WriteHeader(500)
 		return
 	}
 
-	db_user_from_r_token, err := cfg.dbq.GetUserFromRefreshToken(r.Context(), refresh_token_from_header)
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
+		log.Printf("Failed to start transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbq.WithTx(tx)
+
+	// revoking only succeeds once per token so two requests racing with the same token can't both rotate it
+	old_refresh_token, err := qtx.RevokeActiveRefreshToken(r.Context(), auth.HashRefreshToken(refresh_token_from_header))
+	if err != nil {
+		tx.Rollback()
+		cfg.handleRefreshTokenReuse(r, refresh_token_from_header)
 		log.Printf("No valid refresh token found: %s", err)
 		w.WriteHeader(401)
 		w.Write([]byte("No valid refresh token found"))
 		return
 	}
 
-	new_JWT, err := auth.MakeJWT(db_user_from_r_token, cfg.c_secret, 3600 * time.Second)
+	r_token_insert_param := database.CreateRefreshTokenParams{
+		TokenHash: auth.HashRefreshToken(new_refresh_token),
+		ExpiresAt: time.Now().AddDate(0, 0, 60),
+		UserID:    old_refresh_token.UserID,
+		FamilyID:  old_refresh_token.FamilyID,
+		UserAgent: r.UserAgent(),
+		Ip:        clientIP(r),
+	}
+	_, err = qtx.CreateRefreshToken(r.Context(), r_token_insert_param)
+	if err != nil {
+		log.Printf("Failed to insert refresh token to DB: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Failed to commit refresh token rotation: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	new_JWT, err := cfg.keyring.MakeJWT(old_refresh_token.UserID, 3600 * time.Second)
 	if err != nil {
 		log.Printf("Failed to generate token: %s", err)
 		w.WriteHeader(500)
@@ -182,10 +300,12 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 	}
 
 	type returntoken struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	return_token := returntoken{
-		Token: new_JWT,
+		Token:        new_JWT,
+		RefreshToken: new_refresh_token,
 	}
 
 	response_data, err := json.Marshal(return_token)
@@ -199,16 +319,30 @@ func (cfg *apiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 	w.Write(response_data)
 }
 
+// handleRefreshTokenReuse revokes the whole token family when an already
+// rotated or revoked refresh token is presented again, it means someone else
+// has a copy of the token
+func (cfg *apiConfig) handleRefreshTokenReuse(r *http.Request, refresh_token string) {
+	db_refresh_token, err := cfg.dbq.GetRefreshToken(r.Context(), auth.HashRefreshToken(refresh_token))
+	if err != nil || !db_refresh_token.RevokedAt.Valid {
+		return
+	}
+
+	log.Printf("Revoked refresh token reused, revoking token family %s of user %s", db_refresh_token.FamilyID, db_refresh_token.UserID)
+	err = cfg.dbq.RevokeRefreshTokenFamily(r.Context(), db_refresh_token.FamilyID)
+	if err != nil {
+		log.Printf("Failed to revoke refresh token family: %s", err)
+	}
+}
+
 func (cfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request) {
 	refresh_token_from_header, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Printf("Failed to extract refresh token from header: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Failed to extract refresh token from header"))
+		writeAuthorizationError(w, err)
 		return
 	}
 
-	_, err = cfg.dbq.RevokeRefreshToken(r.Context(), refresh_token_from_header)
+	_, err = cfg.dbq.RevokeRefreshToken(r.Context(), auth.HashRefreshToken(refresh_token_from_header))
 	if err != nil {
 		log.Printf("No valid refresh token found: %s", err)
 		w.WriteHeader(401)
@@ -223,20 +357,21 @@ func (cfg *apiConfig) handlerRevokeToken(w http.ResponseWriter, r *http.Request)
 func (cfg *apiConfig) handlerUpdateUserPwEm(w http.ResponseWriter, r *http.Request) {
 	token_from_header, err := auth.GetBearerToken(r.Header)
 	if err != nil {
-		log.Printf("Failed to extract token from header: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Failed to extract token from header"))
+		writeAuthorizationError(w, err)
 		return
 	}
-	user_id_from_token, err := auth.ValidateJWT(token_from_header, cfg.c_secret)
+	user_id_from_token, err := cfg.keyring.ValidateJWT(token_from_header)
 	if err != nil {
-		log.Printf("Token mismatch: %s", err)
-		w.WriteHeader(401)
-		w.Write([]byte("Invalid Token"))
+		writeInvalidToken(w, err)
 		return
 	}
 
-	//TODO maybe before proceeding we need to check if user currently also exists in DB
+	old_user, err := cfg.dbq.GetUserByID(r.Context(), user_id_from_token)
+	if err != nil {
+		log.Printf("Error getting user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
 
 	type RequestedUpdate struct {
 		Password string `json:"password"`
@@ -250,6 +385,14 @@ func (cfg *apiConfig) handlerUpdateUserPwEm(w http.ResponseWriter, r *http.Reque
 		w.WriteHeader(500)
 		return
 	}
+	err = mailer.ValidateAddress(requested_update.Email)
+	if err != nil {
+		writeInvalidEmail(w, err)
+		return
+	}
+	if !cfg.checkPassword(w, r, requested_update.Password) {
+		return
+	}
 	hashed_password, err := auth.HashPassword(requested_update.Password)
 	if err != nil {
 		log.Printf("Error hashing password: %s", err)
@@ -263,12 +406,44 @@ func (cfg *apiConfig) handlerUpdateUserPwEm(w http.ResponseWriter, r *http.Reque
 		HashedPassword: hashed_password,
 	}
 
-	db_user, err := cfg.dbq.UpdatePasswordAndEmail(r.Context(), update_parameters)
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbq.WithTx(tx)
+
+	db_user, err := qtx.UpdatePasswordAndEmail(r.Context(), update_parameters)
 	if err != nil {
 		log.Printf("Error updateing user email and password: %s", err)
 		w.WriteHeader(500)
 		return
 	}
+	// refresh tokens would give new access tokens, so they go with the password
+	err = qtx.RevokeAllUserRefreshTokens(r.Context(), db_user.ID)
+	if err != nil {
+		log.Printf("Error revoking refresh tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("Failed to commit password change: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	// updating the password revoked access tokens issued before it
+	cfg.denylist.UserTokensRevoked(db_user.ID)
+
+	// new email is unverified until the link sent to it is opened
+	if db_user.Email != old_user.Email {
+		err = cfg.sendEmailVerification(r, db_user.ID, db_user.Email)
+		if err != nil {
+			log.Printf("Error creating verification token: %s", err)
+		}
+	}
 
 	response_user := User{
 		ID:        db_user.ID,
@@ -276,6 +451,8 @@ func (cfg *apiConfig) handlerUpdateUserPwEm(w http.ResponseWriter, r *http.Reque
 		UpdatedAt: db_user.UpdatedAt,
 		Email:     db_user.Email,
 		ChirpyRed: db_user.IsChirpyRed,
+		EmailVerified: db_user.EmailVerifiedAt.Valid,
+		Role:      db_user.Role,
 	}
 	response_data, err := json.Marshal(response_user)
 	if err != nil {
@@ -287,3 +464,24 @@ func (cfg *apiConfig) handlerUpdateUserPwEm(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusOK)
 	w.Write(response_data)
 }
+
+// checkPassword writes 400 with the policy violations when the new password is
+// not acceptable. When the breach corpus can't be reached the password is
+// accepted, an outage there should not stop signups.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, r *http.Request, password string) bool {
+	violations, err := cfg.password_policy.Validate(r.Context(), password)
+	if err != nil {
+		log.Printf("Error checking password against breached passwords: %s", err)
+	}
+	if len(violations) == 0 {
+		return true
+	}
+	writeJSON(w, http.StatusBadRequest, struct {
+		Error      string                     `json:"error"`
+		Violations []passwordpolicy.Violation `json:"violations"`
+	}{
+		Error:      "Password does not meet the requirements",
+		Violations: violations,
+	})
+	return false
+}
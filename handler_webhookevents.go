@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log"
+	"time"
+	"errors"
+	"slices"
+	"net/http"
+	"database/sql"
+
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/database"
+)
+
+type WebhookEvent struct {
+	ID          uuid.UUID  `json:"id"`
+	Source      string     `json:"source"`
+	EventID     string     `json:"event_id"`
+	EventType   string     `json:"event_type"`
+	Payload     string     `json:"payload"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Attempts    int32      `json:"attempts"`
+	ReceivedAt  time.Time  `json:"received_at"`
+	ProcessedAt *time.Time `json:"processed_at"`
+}
+
+var webhookStatuses = []string{webhookStatusReceived, webhookStatusProcessing, webhookStatusProcessed, webhookStatusIgnored, webhookStatusFailed}
+
+func (cfg *apiConfig) handlerGetWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	status := sql.NullString{}
+	if r.URL.Query().Get("status") != "" {
+		status = sql.NullString{String: r.URL.Query().Get("status"), Valid: true}
+		if !slices.Contains(webhookStatuses, status.String) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be received, processing, processed, ignored or failed"})
+			return
+		}
+	}
+
+	db_events, err := cfg.dbq.GetWebhookEvents(r.Context(), status)
+	if err != nil {
+		log.Printf("Error getting webhook events: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	result_slice := make([]WebhookEvent, 0, len(db_events))
+	for _, db_event := range db_events {
+		result_slice = append(result_slice, webhookEventFromDB(db_event))
+	}
+	writeJSON(w, http.StatusOK, result_slice)
+}
+
+// handlerReplayWebhookEvent processes stored event again whatever its status
+// is, for example after fixing what made it fail. Events that a delivery is
+// processing right now are not replayed.
+func (cfg *apiConfig) handlerReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	event_id, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	db_event, claimed, err := cfg.claimWebhookEvent(r.Context(), event_id, true)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error claiming webhook event: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	if !claimed {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "event is being processed"})
+		return
+	}
+
+	log.Printf("Admin %s replaying webhook event %s", userIDFromContext(r), db_event.ID)
+	db_event, _, err = cfg.processWebhookEvent(r.Context(), db_event)
+	if err != nil {
+		log.Printf("Error processing webhook event %s: %s", db_event.EventID, err)
+	}
+	writeJSON(w, http.StatusOK, webhookEventFromDB(db_event))
+}
+
+func webhookEventFromDB(db_event database.WebhookEvent) WebhookEvent {
+	result := WebhookEvent{
+		ID:         db_event.ID,
+		Source:     db_event.Source,
+		EventID:    db_event.EventID,
+		EventType:  db_event.EventType,
+		Payload:    db_event.Payload,
+		Status:     db_event.Status,
+		Error:      db_event.Error.String,
+		Attempts:   db_event.Attempts,
+		ReceivedAt: db_event.ReceivedAt,
+	}
+	if db_event.ProcessedAt.Valid {
+		result.ProcessedAt = &db_event.ProcessedAt.Time
+	}
+	return result
+}
@@ -1,28 +1,193 @@
 package main
 
 import (
+	"io"
+	"fmt"
 	"log"
+	"time"
+	"errors"
+	"context"
 	"net/http"
+	"database/sql"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
 
 	"github.com/google/uuid"
 	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/database"
+	"github.com/t6kke/chirpy/internal/pubsub"
 )
 
+const webhookSourcePolka = "polka"
+
+const (
+	webhookStatusReceived   = "received"
+	webhookStatusProcessing = "processing"
+	webhookStatusProcessed  = "processed"
+	webhookStatusIgnored    = "ignored"
+	webhookStatusFailed     = "failed"
+)
+
+const maxWebhookBodySize = 64 * 1024
+
+// handlerPolkaPaymentUpgrade needs the API key when POLKA_KEY is set and
+// valid signature when POLKA_WEBHOOK_SECRETS is set, with both set both are
+// checked
 func (cfg *apiConfig) handlerPolkaPaymentUpgrade(w http.ResponseWriter, r *http.Request) {
-	api_key_from_header, err := auth.GetAPIKey(r.Header)
+	if cfg.p_key != "" {
+		api_key_from_header, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			writeAuthorizationError(w, err)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(api_key_from_header), []byte(cfg.p_key)) != 1 {
+			log.Printf("API key in header does not match")
+			w.Header().Set("WWW-Authenticate", auth.Challenge(auth.SchemeAPIKey, auth.ChallengeInvalidToken, ""))
+			w.WriteHeader(401)
+			return
+		}
+	}
+
+	// signature is over the raw body so it's read before decoding
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
 	if err != nil {
-		log.Printf("Failed to extract API key from header: %s", err)
-		w.WriteHeader(401)
+		log.Printf("Error reading webhook body: %s", err)
+		w.WriteHeader(400)
 		return
 	}
+	if cfg.polka_verifier != nil {
+		err = cfg.polka_verifier.Verify(r.Header.Get("X-Polka-Timestamp"), r.Header.Get("X-Polka-Signature"), body, time.Now())
+		if err != nil {
+			log.Printf("Invalid webhook signature: %s", err)
+			w.WriteHeader(401)
+			return
+		}
+	}
+
+	type InputDataStruct struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+	}
+
+	input_data := InputDataStruct{}
+	decode_err := json.Unmarshal(body, &input_data)
 
-	if api_key_from_header != cfg.p_key {
-		log.Printf("API key in header does not match")
-		w.WriteHeader(401)
+	// Polka retries deliveries, without event id the same body is the same event
+	event_id := input_data.ID
+	if event_id == "" || decode_err != nil {
+		body_hash := sha256.Sum256(body)
+		event_id = "sha256:" + hex.EncodeToString(body_hash[:])
+	}
+	db_event, err := cfg.dbq.RecordWebhookEvent(r.Context(), database.RecordWebhookEventParams{
+		Source:    webhookSourcePolka,
+		EventID:   event_id,
+		EventType: input_data.Event,
+		Payload:   string(body),
+	})
+	if err != nil {
+		log.Printf("Error recording webhook event: %s", err)
+		w.WriteHeader(500)
 		return
 	}
 
+	// bad body is kept so it shows up in the admin list
+	if decode_err != nil {
+		log.Printf("Error decoding parameters: %s", decode_err)
+		_, err = cfg.dbq.SetWebhookEventStatus(r.Context(), database.SetWebhookEventStatusParams{
+			ID:     db_event.ID,
+			Status: webhookStatusFailed,
+			Error:  sql.NullString{String: fmt.Sprintf("failed to decode payload: %s", decode_err), Valid: true},
+		})
+		if err != nil {
+			log.Printf("Error saving webhook event status: %s", err)
+		}
+		w.WriteHeader(400)
+		return
+	}
+
+	if webhookEventHandled(db_event.Status) {
+		log.Printf("Webhook event %s already handled, delivery %d skipped", event_id, db_event.Attempts)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	db_event, claimed, err := cfg.claimWebhookEvent(r.Context(), db_event.ID, false)
+	if err != nil {
+		log.Printf("Error claiming webhook event %s: %s", event_id, err)
+		w.WriteHeader(500)
+		return
+	}
+	if !claimed {
+		if webhookEventHandled(db_event.Status) {
+			log.Printf("Webhook event %s already handled, delivery %d skipped", event_id, db_event.Attempts)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// another delivery is processing it, Polka retries this one later and
+		// gets the outcome then
+		log.Printf("Webhook event %s is being processed by another delivery", event_id)
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	_, status, err := cfg.processWebhookEvent(r.Context(), db_event)
+	if err != nil {
+		log.Printf("Error processing webhook event %s: %s", event_id, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+}
+
+// claimWebhookEvent marks the event as processing so only one request handles
+// it at a time. When the event can't be claimed its current state is
+// returned, replay can claim events that are already processed or ignored.
+func (cfg *apiConfig) claimWebhookEvent(ctx context.Context, id uuid.UUID, replay bool) (database.WebhookEvent, bool, error) {
+	db_event, err := cfg.dbq.ClaimWebhookEvent(ctx, database.ClaimWebhookEventParams{
+		ID:     id,
+		Replay: replay,
+	})
+	if err == nil {
+		return db_event, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return db_event, false, err
+	}
+	db_event, err = cfg.dbq.GetWebhookEvent(ctx, id)
+	return db_event, false, err
+}
+
+// processWebhookEvent handles the stored event and saves the outcome, the
+// returned status is the HTTP status for Polka
+func (cfg *apiConfig) processWebhookEvent(ctx context.Context, db_event database.WebhookEvent) (database.WebhookEvent, int, error) {
+	event_status, status, process_err := cfg.processPolkaEvent(ctx, []byte(db_event.Payload))
+
+	error_message := sql.NullString{}
+	if process_err != nil {
+		error_message = sql.NullString{String: process_err.Error(), Valid: true}
+	}
+	updated_event, err := cfg.dbq.SetWebhookEventStatus(ctx, database.SetWebhookEventStatusParams{
+		ID:     db_event.ID,
+		Status: event_status,
+		Error:  error_message,
+	})
+	if err != nil {
+		return db_event, 500, fmt.Errorf("failed to save webhook event status: %w", err)
+	}
+	return updated_event, status, process_err
+}
+
+// webhookEventHandled tells if retried delivery can be skipped, failed and
+// received events are processed again and events in processing once the
+// delivery handling them is done
+func webhookEventHandled(event_status string) bool {
+	return event_status == webhookStatusProcessed || event_status == webhookStatusIgnored
+}
+
+// parsePolkaEvent decides the outcome of events that don't need the database.
+// Status received means the user in the event still has to be upgraded.
+func parsePolkaEvent(payload []byte) (uuid.UUID, string, int, error) {
 	type InputDataStruct struct {
 		Event string `json:"event"`
 		Data  struct {
@@ -30,36 +195,55 @@ func (cfg *apiConfig) handlerPolkaPaymentUpgrade(w http.ResponseWriter, r *http.
 		} `json:"data"`
 	}
 
-	decoder := json.NewDecoder(r.Body)
 	input_data := InputDataStruct{}
-	err = decoder.Decode(&input_data)
+	err := json.Unmarshal(payload, &input_data)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		w.WriteHeader(500)
-		return
+		return uuid.Nil, webhookStatusFailed, 400, fmt.Errorf("failed to decode payload: %w", err)
 	}
 
 	if input_data.Event != "user.upgraded" {
 		log.Printf("Event type '%s' did not match 'user.upgraded'", input_data.Event)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNoContent)
-		return
+		return uuid.Nil, webhookStatusIgnored, http.StatusNoContent, nil
 	}
 
 	user_id, err := uuid.Parse(input_data.Data.UserID)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
-		w.WriteHeader(500)
-		return
+		return uuid.Nil, webhookStatusFailed, 500, fmt.Errorf("invalid user ID: %w", err)
 	}
+	return user_id, webhookStatusReceived, 0, nil
+}
 
-	_, err = cfg.dbq.UpgradeUserChirpyRed(r.Context(), user_id)
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, payload []byte) (string, int, error) {
+	user_id, event_status, status, err := parsePolkaEvent(payload)
+	if event_status != webhookStatusReceived {
+		return event_status, status, err
+	}
+
+	db_user, err := cfg.dbq.UpgradeUserChirpyRed(ctx, user_id)
 	if err != nil {
-		log.Printf("Error upgradeing user ChirpyRed status: %s", err)
-		w.WriteHeader(404)
-		return
+		return webhookStatusFailed, 404, fmt.Errorf("failed to upgrade user ChirpyRed status: %w", err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusNoContent)
+	// events go through the shared hub and to other instances, no email or
+	// other account details in them
+	event_data, err := json.Marshal(struct {
+		ID        uuid.UUID `json:"id"`
+		UpdatedAt time.Time `json:"updated_at"`
+		ChirpyRed bool      `json:"is_chirpy_red"`
+	}{
+		ID:        db_user.ID,
+		UpdatedAt: db_user.UpdatedAt,
+		ChirpyRed: db_user.IsChirpyRed,
+	})
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+	} else {
+		cfg.publisher.Publish(pubsub.Event{
+			Type:   pubsub.EventUserUpgraded,
+			UserID: db_user.ID,
+			Data:   event_data,
+		})
+	}
+
+	return webhookStatusProcessed, http.StatusNoContent, nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+func TestWebhookEventHandled(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{webhookStatusReceived, false},
+		{webhookStatusProcessing, false},
+		{webhookStatusFailed, false},
+		{webhookStatusProcessed, true},
+		{webhookStatusIgnored, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			if got := webhookEventHandled(tt.status); got != tt.want {
+				t.Errorf("webhookEventHandled(%q) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePolkaEvent(t *testing.T) {
+	user_id := uuid.New()
+
+	tests := []struct {
+		name       string
+		payload    string
+		wantUserID uuid.UUID
+		wantStatus string
+		wantHTTP   int
+		wantErr    bool
+	}{
+		{
+			name:       "Upgrade",
+			payload:    `{"event": "user.upgraded", "data": {"user_id": "` + user_id.String() + `"}}`,
+			wantUserID: user_id,
+			wantStatus: webhookStatusReceived,
+			wantHTTP:   0,
+		},
+		{
+			name:       "Other event",
+			payload:    `{"event": "user.payment_failed", "data": {"user_id": "` + user_id.String() + `"}}`,
+			wantStatus: webhookStatusIgnored,
+			wantHTTP:   http.StatusNoContent,
+		},
+		{
+			name:       "Invalid user ID",
+			payload:    `{"event": "user.upgraded", "data": {"user_id": "abc"}}`,
+			wantStatus: webhookStatusFailed,
+			wantHTTP:   500,
+			wantErr:    true,
+		},
+		{
+			name:       "Malformed payload",
+			payload:    `{"event": `,
+			wantStatus: webhookStatusFailed,
+			wantHTTP:   400,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got_user_id, got_status, got_http, err := parsePolkaEvent([]byte(tt.payload))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parsePolkaEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got_user_id != tt.wantUserID || got_status != tt.wantStatus || got_http != tt.wantHTTP {
+				t.Errorf("parsePolkaEvent() = %v, %q, %d, want %v, %q, %d", got_user_id, got_status, got_http, tt.wantUserID, tt.wantStatus, tt.wantHTTP)
+			}
+			// failed events are retried, ignored ones are not
+			if tt.wantStatus != webhookStatusReceived && webhookEventHandled(got_status) == tt.wantErr {
+				t.Errorf("event with status %q would be handled wrong on retry", got_status)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"net/http"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+	"github.com/google/uuid"
+
+	"github.com/t6kke/chirpy/internal/auth"
+	"github.com/t6kke/chirpy/internal/pubsub"
+)
+
+const (
+	wsPingInterval   = 30 * time.Second
+	wsWriteTimeout   = 10 * time.Second
+	wsOutboundBuffer = 64
+	wsReadLimit      = 4096
+)
+
+const (
+	wsChannelTimeline      = "timeline"
+	wsChannelNotifications = "notifications"
+	wsChannelChirpPrefix   = "chirp:"
+)
+
+type wsClientMessage struct {
+	Type    string `json:"type"`
+	Channel string `json:"channel"`
+	Token   string `json:"token"`
+}
+
+type wsServerMessage struct {
+	Type    string        `json:"type"`
+	Channel string        `json:"channel,omitempty"`
+	Event   *pubsub.Event `json:"event,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+type wsClient struct {
+	cfg      *apiConfig
+	conn     *websocket.Conn
+	user_id  uuid.UUID
+	outbound chan wsServerMessage
+	reauth   chan time.Time
+	cancel   context.CancelFunc
+
+	mu            sync.Mutex
+	subscriptions map[string]*pubsub.Subscription
+	close_code    websocket.StatusCode
+	close_reason  string
+}
+
+func (cfg *apiConfig) handlerWebSocket(w http.ResponseWriter, r *http.Request) {
+	// browsers can't set headers on websocket requests so token is also accepted as query parameter
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token_from_header, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			writeAuthorizationError(w, err)
+			return
+		}
+		token = token_from_header
+	}
+	claims, err := cfg.validateTokenWithScope(r.Context(), token, scopeChirpsRead)
+	if errors.Is(err, errMissingScope) {
+		writeInsufficientScope(w, scopeChirpsRead)
+		return
+	}
+	if err != nil {
+		writeInvalidToken(w, err)
+		return
+	}
+	user_id_from_token, expires_at := claims.UserID, claims.ExpiresAt
+
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to accept websocket connection: %s", err)
+		return
+	}
+	conn.SetReadLimit(wsReadLimit)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &wsClient{
+		cfg:           cfg,
+		conn:          conn,
+		user_id:       user_id_from_token,
+		outbound:      make(chan wsServerMessage, wsOutboundBuffer),
+		reauth:        make(chan time.Time, 1),
+		cancel:        cancel,
+		subscriptions: make(map[string]*pubsub.Subscription),
+	}
+	defer client.unsubscribeAll()
+
+	go client.readLoop()
+	client.writeLoop(ctx, expires_at)
+}
+
+// readLoop runs until the connection is closed, cancelling the read context
+// would make the websocket library drop the connection without a close frame
+func (c *wsClient) readLoop() {
+	for {
+		message := wsClientMessage{}
+		err := wsjson.Read(context.Background(), c.conn, &message)
+		if err != nil {
+			c.cancel()
+			return
+		}
+
+		switch message.Type {
+		case "subscribe":
+			err = c.subscribe(message.Channel)
+			if err != nil {
+				c.send(wsServerMessage{Type: "error", Channel: message.Channel, Error: err.Error()})
+				continue
+			}
+			c.send(wsServerMessage{Type: "subscribed", Channel: message.Channel})
+		case "unsubscribe":
+			c.unsubscribe(message.Channel)
+			c.send(wsServerMessage{Type: "unsubscribed", Channel: message.Channel})
+		case "auth":
+			claims, err := c.cfg.validateTokenWithScope(context.Background(), message.Token, scopeChirpsRead)
+			if err != nil || claims.UserID != c.user_id {
+				c.send(wsServerMessage{Type: "error", Error: "Invalid Token"})
+				continue
+			}
+			select {
+			case <-c.reauth:
+			default:
+			}
+			c.reauth <- claims.ExpiresAt
+			c.send(wsServerMessage{Type: "authenticated"})
+		default:
+			c.send(wsServerMessage{Type: "error", Error: "Unknown message type"})
+		}
+	}
+}
+
+func (c *wsClient) writeLoop(ctx context.Context, expires_at time.Time) {
+	ping_ticker := time.NewTicker(wsPingInterval)
+	defer ping_ticker.Stop()
+	expiry_timer := time.NewTimer(time.Until(expires_at))
+	defer expiry_timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			close_code, close_reason := c.close_code, c.close_reason
+			c.mu.Unlock()
+			if close_code != 0 {
+				c.conn.Close(close_code, close_reason)
+			} else {
+				c.conn.CloseNow()
+			}
+			return
+		case message := <-c.outbound:
+			write_ctx, write_cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+			err := wsjson.Write(write_ctx, c.conn, message)
+			write_cancel()
+			if err != nil {
+				log.Printf("Error writing websocket message: %s", err)
+				c.fail(websocket.StatusTryAgainLater, "slow consumer")
+			}
+		case <-ping_ticker.C:
+			ping_ctx, ping_cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+			err := c.conn.Ping(ping_ctx)
+			ping_cancel()
+			if err != nil {
+				log.Printf("Websocket ping failed: %s", err)
+				c.cancel()
+			}
+		case new_expires_at := <-c.reauth:
+			expiry_timer.Reset(time.Until(new_expires_at))
+		case <-expiry_timer.C:
+			c.fail(websocket.StatusPolicyViolation, "token expired")
+		}
+	}
+}
+
+// send queues message for the client, if the queue is full the client is too
+// slow to keep up and the connection is closed
+func (c *wsClient) send(message wsServerMessage) {
+	select {
+	case c.outbound <- message:
+	default:
+		c.fail(websocket.StatusTryAgainLater, "slow consumer")
+	}
+}
+
+func (c *wsClient) fail(code websocket.StatusCode, reason string) {
+	c.mu.Lock()
+	if c.close_code == 0 {
+		c.close_code = code
+		c.close_reason = reason
+	}
+	c.mu.Unlock()
+	c.cancel()
+}
+
+func (c *wsClient) subscribe(channel string) error {
+	filter, err := c.channelFilter(channel)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.subscriptions[channel]; ok {
+		return nil
+	}
+	subscription, _ := c.cfg.hub.Subscribe(filter, 0)
+	c.subscriptions[channel] = subscription
+
+	go func() {
+		for event := range subscription.C {
+			c.send(wsServerMessage{Type: "event", Channel: channel, Event: &event})
+		}
+		c.mu.Lock()
+		_, still_subscribed := c.subscriptions[channel]
+		c.mu.Unlock()
+		if still_subscribed {
+			// hub dropped the subscription because we did not drain it fast enough
+			c.fail(websocket.StatusTryAgainLater, "slow consumer")
+		}
+	}()
+	return nil
+}
+
+func (c *wsClient) channelFilter(channel string) (pubsub.Filter, error) {
+	switch {
+	case channel == wsChannelTimeline:
+		return func(event pubsub.Event) bool {
+			return event.Type == pubsub.EventChirpCreated || event.Type == pubsub.EventChirpDeleted
+		}, nil
+	case channel == wsChannelNotifications:
+		user_id := c.user_id
+		return func(event pubsub.Event) bool {
+			return event.UserID == user_id && event.Type != pubsub.EventChirpCreated
+		}, nil
+	case strings.HasPrefix(channel, wsChannelChirpPrefix):
+		chirp_id, err := uuid.Parse(strings.TrimPrefix(channel, wsChannelChirpPrefix))
+		if err != nil {
+			return nil, errors.New("Invalid chirp ID in channel")
+		}
+		return func(event pubsub.Event) bool {
+			return event.ChirpID == chirp_id
+		}, nil
+	}
+	return nil, errors.New("Unknown channel")
+}
+
+func (c *wsClient) unsubscribe(channel string) {
+	c.mu.Lock()
+	subscription, ok := c.subscriptions[channel]
+	delete(c.subscriptions, channel)
+	c.mu.Unlock()
+	if ok {
+		subscription.Close()
+	}
+}
+
+func (c *wsClient) unsubscribeAll() {
+	c.mu.Lock()
+	subscriptions := c.subscriptions
+	c.subscriptions = make(map[string]*pubsub.Subscription)
+	c.mu.Unlock()
+	for _, subscription := range subscriptions {
+		subscription.Close()
+	}
+}
@@ -0,0 +1,312 @@
+package activitypub
+
+import (
+	"io"
+	"time"
+	"bytes"
+	"testing"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"crypto/rsa"
+	"encoding/json"
+)
+
+func TestSignAndVerifyRequest(t *testing.T) {
+	public_pem, private_pem, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	private_key, _ := ParsePrivateKeyPEM(private_pem)
+	public_key, _ := ParsePublicKeyPEM(public_pem)
+	_, other_private_pem, _ := GenerateKeyPair()
+	other_private_key, _ := ParsePrivateKeyPEM(other_private_pem)
+
+	fetch_key := func(key_id string) (*rsa.PublicKey, error) {
+		return public_key, nil
+	}
+	body := []byte(`{"type":"Follow"}`)
+
+	tests := []struct {
+		name    string
+		prepare func() (*http.Request, []byte)
+		wantErr bool
+	}{
+		{
+			name: "Valid signature",
+			prepare: func() (*http.Request, []byte) {
+				req := httptest.NewRequest("POST", "http://chirpy.test/ap/users/abc/inbox", bytes.NewReader(body))
+				SignRequest(req, "http://remote.test/users/alice#main-key", private_key, body)
+				return req, body
+			},
+			wantErr: false,
+		},
+		{
+			name: "Body changed after signing",
+			prepare: func() (*http.Request, []byte) {
+				req := httptest.NewRequest("POST", "http://chirpy.test/ap/users/abc/inbox", bytes.NewReader(body))
+				SignRequest(req, "http://remote.test/users/alice#main-key", private_key, body)
+				return req, []byte(`{"type":"Undo"}`)
+			},
+			wantErr: true,
+		},
+		{
+			name: "Signed with another key",
+			prepare: func() (*http.Request, []byte) {
+				req := httptest.NewRequest("POST", "http://chirpy.test/ap/users/abc/inbox", bytes.NewReader(body))
+				SignRequest(req, "http://remote.test/users/alice#main-key", other_private_key, body)
+				return req, body
+			},
+			wantErr: true,
+		},
+		{
+			name: "Stale date",
+			prepare: func() (*http.Request, []byte) {
+				req := httptest.NewRequest("POST", "http://chirpy.test/ap/users/abc/inbox", bytes.NewReader(body))
+				req.Header.Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+				SignRequest(req, "http://remote.test/users/alice#main-key", private_key, body)
+				return req, body
+			},
+			wantErr: true,
+		},
+		{
+			name: "Different path than signed",
+			prepare: func() (*http.Request, []byte) {
+				req := httptest.NewRequest("POST", "http://chirpy.test/ap/users/abc/inbox", bytes.NewReader(body))
+				SignRequest(req, "http://remote.test/users/alice#main-key", private_key, body)
+				req.URL.Path = "/ap/users/def/inbox"
+				return req, body
+			},
+			wantErr: true,
+		},
+		{
+			name: "No signature",
+			prepare: func() (*http.Request, []byte) {
+				req := httptest.NewRequest("POST", "http://chirpy.test/ap/users/abc/inbox", bytes.NewReader(body))
+				return req, body
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, req_body := tt.prepare()
+			_, err := VerifyRequest(req, req_body, fetch_key)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestDeliverToStandInServer runs a local stand-in for a remote fediverse
+// server. It fetches our actor document to verify the delivery signature the
+// same way Mastodon does.
+func TestDeliverToStandInServer(t *testing.T) {
+	public_pem, private_pem, _ := GenerateKeyPair()
+	private_key, _ := ParsePrivateKeyPEM(private_pem)
+
+	local_mux := http.NewServeMux()
+	local_server := httptest.NewServer(local_mux)
+	defer local_server.Close()
+	actor_url := local_server.URL + "/ap/users/alice"
+	key_id := actor_url + "#main-key"
+	local_mux.HandleFunc("GET /ap/users/alice", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		json.NewEncoder(w).Encode(Actor{
+			ID:    actor_url,
+			Type:  "Person",
+			Inbox: actor_url + "/inbox",
+			PublicKey: &PublicKey{
+				ID:           key_id,
+				Owner:        actor_url,
+				PublicKeyPem: public_pem,
+			},
+		})
+	})
+
+	client := newLocalClient()
+	received := make(chan Activity, 1)
+	remote_server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signer := Actor{}
+		_, err := VerifyRequest(r, body, func(key_id string) (*rsa.PublicKey, error) {
+			public_key, actor, err := client.FetchPublicKey(r.Context(), key_id)
+			signer = actor
+			return public_key, err
+		})
+		if err != nil {
+			t.Logf("stand-in server rejected delivery: %v", err)
+			w.WriteHeader(401)
+			return
+		}
+		activity := Activity{}
+		json.Unmarshal(body, &activity)
+		if activity.Actor != signer.ID {
+			w.WriteHeader(401)
+			return
+		}
+		received <- activity
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer remote_server.Close()
+
+	note, _ := json.Marshal(Note{ID: local_server.URL + "/ap/chirps/1", Type: "Note", Content: "<p>hello</p>"})
+	activity := Activity{
+		ID:     local_server.URL + "/ap/chirps/1#create",
+		Type:   "Create",
+		Actor:  actor_url,
+		Object: note,
+	}
+
+	err := client.Deliver(context.Background(), remote_server.URL+"/inbox", activity, key_id, private_key)
+	if err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	got := <-received
+	if got.Type != "Create" || ObjectID(got.Object) != local_server.URL+"/ap/chirps/1" {
+		t.Errorf("stand-in server got %s of %s", got.Type, ObjectID(got.Object))
+	}
+
+	_, other_private_pem, _ := GenerateKeyPair()
+	other_private_key, _ := ParsePrivateKeyPEM(other_private_pem)
+	err = client.Deliver(context.Background(), remote_server.URL+"/inbox", activity, key_id, other_private_key)
+	if err == nil {
+		t.Errorf("Deliver() with wrong key expected error")
+	}
+}
+
+func TestObjectID(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "Link",
+			raw:  `"https://remote.test/users/bob"`,
+			want: "https://remote.test/users/bob",
+		},
+		{
+			name: "Embedded object",
+			raw:  `{"id":"https://remote.test/notes/1","type":"Note"}`,
+			want: "https://remote.test/notes/1",
+		},
+		{
+			name: "Invalid",
+			raw:  `[1,2]`,
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ObjectID(json.RawMessage(tt.raw)); got != tt.want {
+				t.Errorf("ObjectID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchPublicKeyChecksActor(t *testing.T) {
+	public_pem, _, _ := GenerateKeyPair()
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	actor_url := server.URL + "/ap/users/alice"
+	key_id := actor_url + "#main-key"
+
+	tests := []struct {
+		name    string
+		actor   Actor
+		wantErr bool
+	}{
+		{"Valid actor", Actor{ID: actor_url, Inbox: actor_url + "/inbox"}, false},
+		{"Other actor id", Actor{ID: "https://other.test/users/bob", Inbox: actor_url + "/inbox"}, true},
+		{"Inbox on other host", Actor{ID: actor_url, Inbox: "http://169.254.169.254/inbox"}, true},
+		{"Inbox not http", Actor{ID: actor_url, Inbox: "file:///etc/passwd"}, true},
+	}
+	var current Actor
+	mux.HandleFunc("GET /ap/users/alice", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		json.NewEncoder(w).Encode(current)
+	})
+
+	client := newLocalClient()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current = tt.actor
+			current.Type = "Person"
+			current.PublicKey = &PublicKey{ID: key_id, Owner: tt.actor.ID, PublicKeyPem: public_pem}
+			_, _, err := client.FetchPublicKey(context.Background(), key_id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FetchPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// newLocalClient talks to httptest servers on loopback over plain http
+func newLocalClient() *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: requestTimeout},
+		UserAgent:  "chirpy-test",
+		allowLocal: true,
+	}
+}
+
+func TestClientRefusesLocalServers(t *testing.T) {
+	requests := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusOK)
+	})
+	plain_server := httptest.NewServer(handler)
+	defer plain_server.Close()
+	tls_server := httptest.NewTLSServer(handler)
+	defer tls_server.Close()
+
+	client := NewClient("chirpy-test")
+	for _, key_id := range []string{
+		plain_server.URL + "/users/alice#main-key",
+		tls_server.URL + "/users/alice#main-key",
+		"file:///etc/passwd#main-key",
+	} {
+		_, _, err := client.FetchPublicKey(context.Background(), key_id)
+		if err == nil {
+			t.Errorf("FetchPublicKey(%s) expected error", key_id)
+		}
+	}
+	if requests != 0 {
+		t.Errorf("local servers got %d requests, want none", requests)
+	}
+}
+
+func TestRejectLocalAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		wantErr bool
+	}{
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", false},
+		{"127.0.0.1:443", true},
+		{"[::1]:443", true},
+		{"10.0.0.5:443", true},
+		{"192.168.1.1:443", true},
+		{"172.16.0.1:443", true},
+		{"169.254.169.254:80", true},
+		{"[fe80::1]:443", true},
+		{"[fd00::1]:443", true},
+		{"0.0.0.0:443", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := rejectLocalAddress("tcp", tt.address, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("rejectLocalAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
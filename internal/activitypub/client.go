@@ -0,0 +1,210 @@
+package activitypub
+
+import (
+	"io"
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"context"
+	"syscall"
+	"strings"
+	"net"
+	"net/url"
+	"net/http"
+	"crypto/rsa"
+	"encoding/json"
+)
+
+const requestTimeout = 5 * time.Second
+const maxResponseSize = 1 << 20
+const maxRedirects = 3
+
+// Client delivers signed activities to remote inboxes and fetches remote actors.
+// The URLs come from other servers, even from unsigned requests, so only https
+// is used and connections to loopback, private and link-local addresses are
+// refused when dialing, after DNS is resolved.
+type Client struct {
+	HTTPClient *http.Client
+	UserAgent  string
+	// tests run against local plain http servers
+	allowLocal bool
+}
+
+func NewClient(userAgent string) *Client {
+	dialer := &net.Dialer{
+		Timeout: requestTimeout,
+		Control: rejectLocalAddress,
+	}
+	transport := &http.Transport{
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    requestTimeout,
+		ResponseHeaderTimeout:  requestTimeout,
+		MaxResponseHeaderBytes: 64 << 10,
+	}
+	return &Client{
+		HTTPClient: &http.Client{
+			Timeout:   requestTimeout,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "https" {
+					return fmt.Errorf("redirect to %s is not https", req.URL)
+				}
+				return nil
+			},
+		},
+		UserAgent: userAgent,
+	}
+}
+
+// rejectLocalAddress is the dialer Control hook, it sees the IP that is
+// actually connected to so DNS answers changing between lookups don't matter
+func rejectLocalAddress(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("address %s is not public", address)
+	}
+	return nil
+}
+
+func (c *Client) checkURL(raw_url string) error {
+	parsed_url, err := url.Parse(raw_url)
+	if err != nil {
+		return err
+	}
+	if parsed_url.Scheme == "https" || (c.allowLocal && parsed_url.Scheme == "http") {
+		return nil
+	}
+	return fmt.Errorf("only https URLs are allowed: %s", raw_url)
+}
+
+func (c *Client) Deliver(ctx context.Context, inboxURL string, activity interface{}, keyID string, privateKey *rsa.PrivateKey) error {
+	err := c.checkURL(inboxURL)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", inboxURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("Accept", ContentType)
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	err = SignRequest(req, keyID, privateKey, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("inbox %s responded with status %d", inboxURL, resp.StatusCode)
+	}
+	return nil
+}
+
+// FetchActor gets the actor document from actorURL. Many servers require
+// signed fetches so the request is signed when privateKey is given.
+func (c *Client) FetchActor(ctx context.Context, actorURL string, keyID string, privateKey *rsa.PrivateKey) (Actor, error) {
+	err := c.checkURL(actorURL)
+	if err != nil {
+		return Actor{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", actorURL, nil)
+	if err != nil {
+		return Actor{}, err
+	}
+	req.Header.Set("Accept", ContentType)
+	req.Header.Set("User-Agent", c.UserAgent)
+
+	if privateKey != nil {
+		err = SignRequest(req, keyID, privateKey, nil)
+		if err != nil {
+			return Actor{}, err
+		}
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return Actor{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Actor{}, fmt.Errorf("actor %s responded with status %d", actorURL, resp.StatusCode)
+	}
+
+	actor := Actor{}
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&actor)
+	if err != nil {
+		return Actor{}, err
+	}
+	if actor.ID == "" || actor.Inbox == "" {
+		return Actor{}, errors.New("actor document is missing id or inbox")
+	}
+	return actor, nil
+}
+
+// FetchPublicKey resolves keyID, which is usually the actor URL with a
+// fragment, to the actors public key. The actor document has to be the one
+// at the URL and its inbox on the same host, otherwise any server could
+// claim to be someone else or point deliveries at internal addresses.
+func (c *Client) FetchPublicKey(ctx context.Context, keyID string) (*rsa.PublicKey, Actor, error) {
+	actor_url, _, _ := strings.Cut(keyID, "#")
+	actor, err := c.FetchActor(ctx, actor_url, "", nil)
+	if err != nil {
+		return nil, Actor{}, err
+	}
+	if actor.ID != actor_url {
+		return nil, Actor{}, fmt.Errorf("actor at %s has id %s", actor_url, actor.ID)
+	}
+	if c.checkURL(actor.Inbox) != nil || !sameHost(actor.ID, actor.Inbox) {
+		return nil, Actor{}, fmt.Errorf("actor %s has inbox %s on another host", actor.ID, actor.Inbox)
+	}
+	if actor.PublicKey == nil || actor.PublicKey.ID != keyID || actor.PublicKey.Owner != actor.ID {
+		return nil, Actor{}, fmt.Errorf("actor %s does not have key %s", actor.ID, keyID)
+	}
+	public_key, err := ParsePublicKeyPEM(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return nil, Actor{}, err
+	}
+	return public_key, actor, nil
+}
+
+func sameHost(a string, b string) bool {
+	a_url, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	b_url, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	if b_url.Scheme != "https" && b_url.Scheme != "http" {
+		return false
+	}
+	return a_url.Host != "" && a_url.Host == b_url.Host
+}
@@ -0,0 +1,230 @@
+package activitypub
+
+import (
+	"fmt"
+	"time"
+	"errors"
+	"strings"
+	"net/http"
+	"crypto"
+	"crypto/rsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/base64"
+)
+
+// Signatures follow draft-cavage-http-signatures which is what Mastodon and
+// most other ActivityPub servers use
+const signatureAlgorithm = "rsa-sha256"
+const MaxClockSkew = 5 * time.Minute
+
+var signedHeadersWithBody = []string{"(request-target)", "host", "date", "digest"}
+var signedHeadersNoBody = []string{"(request-target)", "host", "date"}
+
+type KeyFetcher func(keyID string) (*rsa.PublicKey, error)
+
+func GenerateKeyPair() (string, string, error) {
+	private_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+
+	private_der := x509.MarshalPKCS1PrivateKey(private_key)
+	private_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: private_der})
+
+	public_der, err := x509.MarshalPKIXPublicKey(&private_key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	public_pem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public_der})
+
+	return string(public_pem), string(private_pem), nil
+}
+
+func ParsePrivateKeyPEM(private_pem string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(private_pem))
+	if block == nil {
+		return nil, errors.New("no PEM data found in private key")
+	}
+	private_key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err == nil {
+		return private_key, nil
+	}
+	parsed_key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsa_key, ok := parsed_key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsa_key, nil
+}
+
+func ParsePublicKeyPEM(public_pem string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(public_pem))
+	if block == nil {
+		return nil, errors.New("no PEM data found in public key")
+	}
+	parsed_key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		rsa_key, pkcs1_err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if pkcs1_err != nil {
+			return nil, err
+		}
+		return rsa_key, nil
+	}
+	rsa_key, ok := parsed_key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not an RSA key")
+	}
+	return rsa_key, nil
+}
+
+// SignRequest adds Date, Digest (when body is not nil) and Signature headers to req
+func SignRequest(req *http.Request, keyID string, privateKey *rsa.PrivateKey, body []byte) error {
+	if req.Header.Get("Date") == "" {
+		req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	signed_headers := signedHeadersNoBody
+	if body != nil {
+		req.Header.Set("Digest", bodyDigest(body))
+		signed_headers = signedHeadersWithBody
+	}
+
+	signing_string, err := buildSigningString(req, signed_headers)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signing_string))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Signature", fmt.Sprintf(
+		"keyId=\"%s\",algorithm=\"%s\",headers=\"%s\",signature=\"%s\"",
+		keyID,
+		signatureAlgorithm,
+		strings.Join(signed_headers, " "),
+		base64.StdEncoding.EncodeToString(signature),
+	))
+	return nil
+}
+
+// VerifyRequest checks the Signature header of req and returns the keyId that
+// signed it. body is the already read request body, it's checked against the
+// Digest header which must then be part of the signature.
+func VerifyRequest(req *http.Request, body []byte, fetchKey KeyFetcher) (string, error) {
+	signature_header := req.Header.Get("Signature")
+	if signature_header == "" {
+		return "", errors.New("No \"Signature\" in header")
+	}
+	params := parseSignatureHeader(signature_header)
+
+	key_id := params["keyId"]
+	if key_id == "" {
+		return "", errors.New("signature has no keyId")
+	}
+	if params["algorithm"] != "" && params["algorithm"] != signatureAlgorithm && params["algorithm"] != "hs2019" {
+		return "", fmt.Errorf("unsupported signature algorithm %s", params["algorithm"])
+	}
+	signed_headers := strings.Fields(strings.ToLower(params["headers"]))
+	if len(signed_headers) == 0 {
+		signed_headers = []string{"date"}
+	}
+
+	if !containsString(signed_headers, "(request-target)") || !containsString(signed_headers, "date") {
+		return "", errors.New("signature must cover (request-target) and date")
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return "", fmt.Errorf("invalid Date header: %w", err)
+	}
+	if time.Since(date) > MaxClockSkew || time.Until(date) > MaxClockSkew {
+		return "", errors.New("Date header too far from current time")
+	}
+
+	if len(body) > 0 {
+		if !containsString(signed_headers, "digest") {
+			return "", errors.New("signature must cover digest when request has body")
+		}
+		if req.Header.Get("Digest") != bodyDigest(body) {
+			return "", errors.New("Digest header does not match body")
+		}
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return "", fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	public_key, err := fetchKey(key_id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public key %s: %w", key_id, err)
+	}
+
+	signing_string, err := buildSigningString(req, signed_headers)
+	if err != nil {
+		return "", err
+	}
+	hashed := sha256.Sum256([]byte(signing_string))
+	err = rsa.VerifyPKCS1v15(public_key, crypto.SHA256, hashed[:], signature)
+	if err != nil {
+		return "", errors.New("signature verification failed")
+	}
+
+	return key_id, nil
+}
+
+func buildSigningString(req *http.Request, signed_headers []string) (string, error) {
+	lines := make([]string, 0, len(signed_headers))
+	for _, header := range signed_headers {
+		switch header {
+		case "(request-target)":
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(req.Method), req.URL.RequestURI()))
+		case "host":
+			host := req.Host
+			if host == "" {
+				host = req.URL.Host
+			}
+			lines = append(lines, "host: "+host)
+		default:
+			value := req.Header.Get(header)
+			if value == "" {
+				return "", fmt.Errorf("signed header %s missing from request", header)
+			}
+			lines = append(lines, header+": "+value)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		params[key] = strings.Trim(value, "\"")
+	}
+	return params
+}
+
+func bodyDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(digest[:])
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package activitypub
+
+import (
+	"encoding/json"
+)
+
+const (
+	ContentType       = "application/activity+json"
+	JRDContentType    = "application/jrd+json"
+	ActivityStreamsNS = "https://www.w3.org/ns/activitystreams"
+	SecurityNS        = "https://w3id.org/security/v1"
+	PublicCollection  = "https://www.w3.org/ns/activitystreams#Public"
+)
+
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type Actor struct {
+	Context           []string   `json:"@context,omitempty"`
+	ID                string     `json:"id"`
+	Type              string     `json:"type"`
+	PreferredUsername string     `json:"preferredUsername,omitempty"`
+	Name              string     `json:"name,omitempty"`
+	Inbox             string     `json:"inbox"`
+	Outbox            string     `json:"outbox,omitempty"`
+	Followers         string     `json:"followers,omitempty"`
+	PublicKey         *PublicKey `json:"publicKey,omitempty"`
+}
+
+type Note struct {
+	Context      string   `json:"@context,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	AttributedTo string   `json:"attributedTo,omitempty"`
+	Content      string   `json:"content,omitempty"`
+	Published    string   `json:"published,omitempty"`
+	To           []string `json:"to,omitempty"`
+	Cc           []string `json:"cc,omitempty"`
+}
+
+// Activity is used both for building outgoing activities and decoding
+// incoming ones, Object is kept raw since it can be a link or an embedded object
+type Activity struct {
+	Context   string          `json:"@context,omitempty"`
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor"`
+	Object    json.RawMessage `json:"object"`
+	Published string          `json:"published,omitempty"`
+	To        []string        `json:"to,omitempty"`
+	Cc        []string        `json:"cc,omitempty"`
+}
+
+type OrderedCollection struct {
+	Context      string        `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	TotalItems   int           `json:"totalItems"`
+	OrderedItems []interface{} `json:"orderedItems"`
+}
+
+// PagedCollection is OrderedCollection too big to return at once, the items
+// are in OrderedCollectionPage objects starting from First
+type PagedCollection struct {
+	Context    string `json:"@context,omitempty"`
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	TotalItems int    `json:"totalItems"`
+	First      string `json:"first"`
+}
+
+type OrderedCollectionPage struct {
+	Context      string        `json:"@context,omitempty"`
+	ID           string        `json:"id"`
+	Type         string        `json:"type"`
+	PartOf       string        `json:"partOf"`
+	Next         string        `json:"next,omitempty"`
+	Prev         string        `json:"prev,omitempty"`
+	OrderedItems []interface{} `json:"orderedItems"`
+}
+
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+// ObjectID returns the id of an object that is either given as a plain link
+// or as an embedded object with an id
+func ObjectID(raw json.RawMessage) string {
+	link := ""
+	err := json.Unmarshal(raw, &link)
+	if err == nil {
+		return link
+	}
+	object := struct {
+		ID string `json:"id"`
+	}{}
+	err = json.Unmarshal(raw, &object)
+	if err != nil {
+		return ""
+	}
+	return object.ID
+}
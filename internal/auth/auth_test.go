@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 	"net/http"
+	"encoding/hex"
 
 	"github.com/google/uuid"
 )
@@ -112,6 +113,30 @@ func TestCheckJWT(t *testing.T) {
 }
 
 
+func TestValidateJWTWithExpiry(t *testing.T) {
+	userID := uuid.New()
+	before := time.Now().Add(time.Hour).Add(-time.Second)
+	validToken, _ := MakeJWT(userID, "secret", time.Hour)
+	expiredToken, _ := MakeJWT(userID, "secret", -time.Minute)
+
+	gotUserID, gotExpiry, err := ValidateJWTWithExpiry(validToken, "secret")
+	if err != nil {
+		t.Fatalf("ValidateJWTWithExpiry() error = %v", err)
+	}
+	if gotUserID != userID {
+		t.Errorf("ValidateJWTWithExpiry() gotUserID = %v, want %v", gotUserID, userID)
+	}
+	if gotExpiry.Before(before) || gotExpiry.After(time.Now().Add(time.Hour)) {
+		t.Errorf("ValidateJWTWithExpiry() gotExpiry = %v, want about one hour from now", gotExpiry)
+	}
+
+	_, _, err = ValidateJWTWithExpiry(expiredToken, "secret")
+	if err == nil {
+		t.Errorf("ValidateJWTWithExpiry() expected error for expired token")
+	}
+}
+
+
 func TestCheckBarerTokenExtract(t *testing.T) {
 	headers_ok := http.Header{}
 	headers_ok.Set("Authorization", "Bearer 1234ABCD")
@@ -209,3 +234,63 @@ func TestCheckApiKeyExtract(t *testing.T) {
 		})
 	}
 }
+
+func TestHashRefreshToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		wantHash string
+	}{
+		{
+			name:     "Known value",
+			token:    "abc",
+			wantHash: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HashRefreshToken(tt.token)
+			if got != tt.wantHash {
+				t.Errorf("HashRefreshToken() = %v, want %v", got, tt.wantHash)
+			}
+		})
+	}
+
+	// generated tokens are hex too, the hash must not be the token itself
+	token, _ := MakeRefreshToken()
+	got := HashRefreshToken(token)
+	decoded, err := hex.DecodeString(got)
+	if err != nil || len(decoded) != 32 {
+		t.Errorf("HashRefreshToken() = %v, want 64 hex characters", got)
+	}
+	if got == token {
+		t.Errorf("HashRefreshToken() returned the token itself")
+	}
+}
+
+func TestPersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatalf("MakePersonalAccessToken() error = %v", err)
+	}
+	jwt_token, _ := MakeJWT(uuid.New(), "secret", time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{name: "Personal access token", token: token, want: true},
+		{name: "JWT", token: jwt_token, want: false},
+		{name: "Refresh token", token: HashRefreshToken("abc"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPersonalAccessToken(tt.token); got != tt.want {
+				t.Errorf("IsPersonalAccessToken() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	"net/http"
+)
+
+const (
+	SchemeBearer = "Bearer"
+	SchemeAPIKey = "ApiKey"
+)
+
+// error codes of WWW-Authenticate challenges, RFC 6750 section 3.1
+const (
+	ChallengeInvalidRequest    = "invalid_request"
+	ChallengeInvalidToken      = "invalid_token"
+	ChallengeInsufficientScope = "insufficient_scope"
+)
+
+var (
+	ErrNoAuthorization        = errors.New("no Authorization header")
+	ErrMalformedAuthorization = errors.New("malformed Authorization header")
+	ErrWrongScheme            = errors.New("wrong Authorization scheme")
+)
+
+// AuthorizationError is returned by ParseAuthorization, Err is one of
+// ErrNoAuthorization, ErrMalformedAuthorization or ErrWrongScheme
+type AuthorizationError struct {
+	Scheme string
+	Err    error
+}
+
+func (e *AuthorizationError) Error() string {
+	return fmt.Sprintf("%s, expected %s", e.Err, e.Scheme)
+}
+
+func (e *AuthorizationError) Unwrap() error {
+	return e.Err
+}
+
+// Challenge is the WWW-Authenticate value for the response. Without any
+// credentials there is no error code, RFC 6750 asks for that.
+func (e *AuthorizationError) Challenge() string {
+	if errors.Is(e.Err, ErrNoAuthorization) {
+		return Challenge(e.Scheme, "", "")
+	}
+	return Challenge(e.Scheme, ChallengeInvalidRequest, e.Err.Error())
+}
+
+// Challenge builds WWW-Authenticate value, errorCode and description are
+// left out when empty
+func Challenge(scheme, errorCode, description string) string {
+	challenge := scheme + ` realm="chirpy"`
+	if errorCode != "" {
+		challenge += `, error="` + errorCode + `"`
+	}
+	if description != "" {
+		challenge += `, error_description="` + strings.ReplaceAll(description, `"`, `'`) + `"`
+	}
+	return challenge
+}
+
+// ParseAuthorization returns the credentials from "<scheme> <credentials>"
+// Authorization header. Scheme is matched ignoring case and any amount of
+// spaces or tabs around the parts is allowed, the credentials have to be a
+// single token68 value.
+func ParseAuthorization(headers http.Header, scheme string) (string, error) {
+	values := headers.Values("Authorization")
+	if len(values) > 1 {
+		return "", &AuthorizationError{Scheme: scheme, Err: ErrMalformedAuthorization}
+	}
+	if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+		return "", &AuthorizationError{Scheme: scheme, Err: ErrNoAuthorization}
+	}
+
+	parts := strings.Fields(values[0])
+	if !strings.EqualFold(parts[0], scheme) {
+		return "", &AuthorizationError{Scheme: scheme, Err: ErrWrongScheme}
+	}
+	if len(parts) != 2 || !isToken68(parts[1]) {
+		return "", &AuthorizationError{Scheme: scheme, Err: ErrMalformedAuthorization}
+	}
+	return parts[1], nil
+}
+
+func GetBearerToken(headers http.Header) (string, error) {
+	return ParseAuthorization(headers, SchemeBearer)
+}
+
+func GetAPIKey(headers http.Header) (string, error) {
+	return ParseAuthorization(headers, SchemeAPIKey)
+}
+
+// isToken68 checks the RFC 7235 token68 syntax, letters, digits and -._~+/
+// followed by optional = padding
+func isToken68(value string) bool {
+	value = strings.TrimRight(value, "=")
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~', c == '+', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
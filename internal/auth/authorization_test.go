@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"net/http"
+)
+
+func TestParseAuthorization(t *testing.T) {
+	tests := []struct {
+		name       string
+		values     []string
+		scheme     string
+		wantToken  string
+		wantErr    error
+		wantHeader string
+	}{
+		{
+			name:      "Bearer token",
+			values:    []string{"Bearer abc.def-ghi_jkl"},
+			scheme:    SchemeBearer,
+			wantToken: "abc.def-ghi_jkl",
+		},
+		{
+			name:      "Scheme ignores case",
+			values:    []string{"bEaReR abc"},
+			scheme:    SchemeBearer,
+			wantToken: "abc",
+		},
+		{
+			name:      "Extra whitespace",
+			values:    []string{"  Bearer \t  abc==  "},
+			scheme:    SchemeBearer,
+			wantToken: "abc==",
+		},
+		{
+			name:       "No header",
+			values:     nil,
+			scheme:     SchemeBearer,
+			wantErr:    ErrNoAuthorization,
+			wantHeader: `Bearer realm="chirpy"`,
+		},
+		{
+			name:       "Empty header",
+			values:     []string{"   "},
+			scheme:     SchemeBearer,
+			wantErr:    ErrNoAuthorization,
+			wantHeader: `Bearer realm="chirpy"`,
+		},
+		{
+			name:       "API key is not bearer token",
+			values:     []string{"ApiKey abc"},
+			scheme:     SchemeBearer,
+			wantErr:    ErrWrongScheme,
+			wantHeader: `Bearer realm="chirpy", error="invalid_request", error_description="wrong Authorization scheme"`,
+		},
+		{
+			name:    "Bearer token is not API key",
+			values:  []string{"Bearer abc"},
+			scheme:  SchemeAPIKey,
+			wantErr: ErrWrongScheme,
+		},
+		{
+			name:    "Only credentials",
+			values:  []string{"abc"},
+			scheme:  SchemeBearer,
+			wantErr: ErrWrongScheme,
+		},
+		{
+			name:    "Only scheme",
+			values:  []string{"Bearer"},
+			scheme:  SchemeBearer,
+			wantErr: ErrMalformedAuthorization,
+		},
+		{
+			name:    "Space in credentials",
+			values:  []string{"Bearer abc def"},
+			scheme:  SchemeBearer,
+			wantErr: ErrMalformedAuthorization,
+		},
+		{
+			name:    "Not token68",
+			values:  []string{"Bearer abc,def"},
+			scheme:  SchemeBearer,
+			wantErr: ErrMalformedAuthorization,
+		},
+		{
+			name:    "Padding only",
+			values:  []string{"Bearer =="},
+			scheme:  SchemeBearer,
+			wantErr: ErrMalformedAuthorization,
+		},
+		{
+			name:    "Two headers",
+			values:  []string{"Bearer abc", "Bearer def"},
+			scheme:  SchemeBearer,
+			wantErr: ErrMalformedAuthorization,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := http.Header{}
+			for _, value := range tt.values {
+				headers.Add("Authorization", value)
+			}
+			token, err := ParseAuthorization(headers, tt.scheme)
+			if !errors.Is(err, tt.wantErr) || (err != nil) != (tt.wantErr != nil) {
+				t.Fatalf("ParseAuthorization() error = %v, want %v", err, tt.wantErr)
+			}
+			if token != tt.wantToken {
+				t.Errorf("ParseAuthorization() token = %v, want %v", token, tt.wantToken)
+			}
+			if tt.wantHeader == "" {
+				return
+			}
+			auth_err := &AuthorizationError{}
+			if !errors.As(err, &auth_err) {
+				t.Fatalf("ParseAuthorization() error is not AuthorizationError")
+			}
+			if auth_err.Challenge() != tt.wantHeader {
+				t.Errorf("Challenge() = %v, want %v", auth_err.Challenge(), tt.wantHeader)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"sync"
+	"time"
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// RevocationStore is where revoked access tokens are persisted, the
+// database queries implement it
+type RevocationStore interface {
+	IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetTokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error)
+}
+
+type cachedTokenState struct {
+	revoked   bool
+	keepUntil time.Time
+}
+
+type cachedUserState struct {
+	revokedBefore time.Time
+	keepUntil     time.Time
+}
+
+// Denylist checks access tokens against revoked token IDs (jti) and the per
+// user timestamp before which all tokens are revoked. Results are cached so
+// not every request goes to the store, revocations done on other instances
+// are noticed after the cache TTL.
+type Denylist struct {
+	store     RevocationStore
+	cacheTTL  time.Duration
+	mu        sync.Mutex
+	tokens    map[string]cachedTokenState
+	users     map[uuid.UUID]cachedUserState
+	lastSweep time.Time
+}
+
+func NewDenylist(store RevocationStore, cacheTTL time.Duration) *Denylist {
+	return &Denylist{
+		store:     store,
+		cacheTTL:  cacheTTL,
+		tokens:    make(map[string]cachedTokenState),
+		users:     make(map[uuid.UUID]cachedUserState),
+		lastSweep: time.Now(),
+	}
+}
+
+func (d *Denylist) IsRevoked(claims TokenClaims) (bool, error) {
+	revoked_before, err := d.tokensRevokedBefore(claims.UserID)
+	if err != nil {
+		return false, err
+	}
+	// iat only has second precision, a token from the same second as the
+	// revocation could be from before it so it's revoked as well
+	if !claims.IssuedAt.After(revoked_before.Truncate(time.Second)) {
+		return true, nil
+	}
+	if claims.TokenID == "" {
+		return false, nil
+	}
+	return d.isTokenRevoked(claims.TokenID, claims.ExpiresAt)
+}
+
+// IsRevokedAt checks tokens that only have a creation time and no jti in the
+// denylist, like personal access tokens. createdAt has full precision so
+// it's compared as is.
+func (d *Denylist) IsRevokedAt(userID uuid.UUID, createdAt time.Time) (bool, error) {
+	revoked_before, err := d.tokensRevokedBefore(userID)
+	if err != nil {
+		return false, err
+	}
+	return createdAt.Before(revoked_before), nil
+}
+
+// TokenRevoked updates the cache after the token was revoked in the store
+func (d *Denylist) TokenRevoked(claims TokenClaims) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tokens[claims.TokenID] = cachedTokenState{revoked: true, keepUntil: claims.ExpiresAt}
+}
+
+// UserTokensRevoked drops the cached timestamp after it was changed in the store
+func (d *Denylist) UserTokensRevoked(userID uuid.UUID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.users, userID)
+}
+
+func (d *Denylist) tokensRevokedBefore(userID uuid.UUID) (time.Time, error) {
+	now := time.Now()
+	d.mu.Lock()
+	cached, ok := d.users[userID]
+	d.mu.Unlock()
+	if ok && now.Before(cached.keepUntil) {
+		return cached.revokedBefore, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	revoked_before, err := d.store.GetTokensRevokedBefore(ctx, userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[userID] = cachedUserState{revokedBefore: revoked_before, keepUntil: now.Add(d.cacheTTL)}
+	d.sweep(now)
+	return revoked_before, nil
+}
+
+func (d *Denylist) isTokenRevoked(tokenID string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	d.mu.Lock()
+	cached, ok := d.tokens[tokenID]
+	d.mu.Unlock()
+	if ok && (cached.revoked || now.Before(cached.keepUntil)) {
+		return cached.revoked, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	revoked, err := d.store.IsAccessTokenRevoked(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+
+	// revoked tokens stay revoked so they are kept until the token expires
+	keep_until := now.Add(d.cacheTTL)
+	if revoked {
+		keep_until = expiresAt
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tokens[tokenID] = cachedTokenState{revoked: revoked, keepUntil: keep_until}
+	d.sweep(now)
+	return revoked, nil
+}
+
+// sweep removes outdated cache entries once a minute, caller holds the lock
+func (d *Denylist) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < time.Minute {
+		return
+	}
+	d.lastSweep = now
+	for token_id, cached := range d.tokens {
+		if now.After(cached.keepUntil) {
+			delete(d.tokens, token_id)
+		}
+	}
+	for user_id, cached := range d.users {
+		if now.After(cached.keepUntil) {
+			delete(d.users, user_id)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"sync"
+	"time"
+	"testing"
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type fakeRevocationStore struct {
+	mu            sync.Mutex
+	revoked       map[string]bool
+	revokedBefore map[uuid.UUID]time.Time
+	lookups       int
+}
+
+func (s *fakeRevocationStore) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	return s.revoked[jti], nil
+}
+
+func (s *fakeRevocationStore) GetTokensRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookups++
+	return s.revokedBefore[userID], nil
+}
+
+func TestDenylist(t *testing.T) {
+	userID := uuid.New()
+	store := &fakeRevocationStore{
+		revoked:       make(map[string]bool),
+		revokedBefore: make(map[uuid.UUID]time.Time),
+	}
+	denylist := NewDenylist(store, time.Hour)
+	keyring, _ := NewKeyring(map[string]string{"a": "secret"}, "a")
+	keyring.SetDenylist(denylist)
+
+	token, _ := keyring.MakeJWT(userID, time.Hour)
+	claims, err := keyring.ValidateAccessToken(token)
+	if err != nil {
+		t.Fatalf("ValidateAccessToken() error = %v", err)
+	}
+	if claims.TokenID == "" {
+		t.Fatalf("MakeJWT() token has no jti")
+	}
+
+	// second validation is answered from the cache
+	lookups := store.lookups
+	keyring.ValidateJWT(token)
+	if store.lookups != lookups {
+		t.Errorf("ValidateJWT() went to the store %d times, want cached result", store.lookups-lookups)
+	}
+
+	// revoked on this instance
+	store.revoked[claims.TokenID] = true
+	denylist.TokenRevoked(claims)
+	if _, err := keyring.ValidateJWT(token); err == nil {
+		t.Errorf("ValidateJWT() accepted revoked token")
+	}
+
+	other_token, _ := keyring.MakeJWT(userID, time.Hour)
+	if _, err := keyring.ValidateJWT(other_token); err != nil {
+		t.Errorf("ValidateJWT() rejected token that was not revoked: %v", err)
+	}
+
+	// all tokens issued before now are revoked, e.g. after password change
+	store.revokedBefore[userID] = time.Now().Add(time.Second)
+	denylist.UserTokensRevoked(userID)
+	if _, err := keyring.ValidateJWT(other_token); err == nil {
+		t.Errorf("ValidateJWT() accepted token issued before user revocation")
+	}
+}
+
+func TestDenylistCacheExpiry(t *testing.T) {
+	userID := uuid.New()
+	store := &fakeRevocationStore{
+		revoked:       make(map[string]bool),
+		revokedBefore: make(map[uuid.UUID]time.Time),
+	}
+	denylist := NewDenylist(store, 10*time.Millisecond)
+	claims := TokenClaims{
+		UserID:    userID,
+		TokenID:   "jti",
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	if revoked, _ := denylist.IsRevoked(claims); revoked {
+		t.Fatalf("IsRevoked() = true before revocation")
+	}
+	// revoked by another instance, noticed once the cache entry is outdated
+	store.revoked["jti"] = true
+	time.Sleep(20 * time.Millisecond)
+	if revoked, _ := denylist.IsRevoked(claims); !revoked {
+		t.Errorf("IsRevoked() = false after cache TTL")
+	}
+}
+
+func TestDenylistSameSecond(t *testing.T) {
+	userID := uuid.New()
+	store := &fakeRevocationStore{
+		revoked:       make(map[string]bool),
+		revokedBefore: make(map[uuid.UUID]time.Time),
+	}
+	denylist := NewDenylist(store, time.Hour)
+	revoked_at := time.Date(2024, 1, 1, 12, 0, 0, 500*int(time.Millisecond), time.UTC)
+	store.revokedBefore[userID] = revoked_at
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"Earlier second", revoked_at.Add(-time.Second).Truncate(time.Second), true},
+		{"Same second", revoked_at.Truncate(time.Second), true},
+		{"Next second", revoked_at.Add(time.Second).Truncate(time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := TokenClaims{UserID: userID, IssuedAt: tt.issuedAt}
+			revoked, err := denylist.IsRevoked(claims)
+			if err != nil {
+				t.Fatalf("IsRevoked() error = %v", err)
+			}
+			if revoked != tt.want {
+				t.Errorf("IsRevoked() = %v, want %v", revoked, tt.want)
+			}
+		})
+	}
+}
+
+func TestDenylistIsRevokedAt(t *testing.T) {
+	userID := uuid.New()
+	store := &fakeRevocationStore{
+		revoked:       make(map[string]bool),
+		revokedBefore: make(map[uuid.UUID]time.Time),
+	}
+	denylist := NewDenylist(store, time.Hour)
+	created_at := time.Now().UTC()
+
+	if revoked, _ := denylist.IsRevokedAt(userID, created_at); revoked {
+		t.Fatalf("IsRevokedAt() = true before revocation")
+	}
+
+	// logout-all after the personal access token was created
+	store.revokedBefore[userID] = created_at.Add(time.Millisecond)
+	denylist.UserTokensRevoked(userID)
+	if revoked, _ := denylist.IsRevokedAt(userID, created_at); !revoked {
+		t.Errorf("IsRevokedAt() = false for token created before revocation")
+	}
+	if revoked, _ := denylist.IsRevokedAt(userID, created_at.Add(2*time.Millisecond)); revoked {
+		t.Errorf("IsRevokedAt() = true for token created after revocation")
+	}
+}
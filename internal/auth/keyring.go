@@ -0,0 +1,396 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"time"
+	"errors"
+	"slices"
+	"strings"
+	"math/big"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/ed25519"
+	"encoding/pem"
+	"encoding/base64"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// DefaultKeyID is used for tokens that have no kid header, those were
+// issued before keyring existed and are signed with the CHIRPY_SECRET
+const DefaultKeyID = "default"
+
+type keyringKey struct {
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// Keyring holds every key that JWTs are accepted with and the one new
+// tokens are signed with. Keys are rotated by first adding the new key to all
+// instances, then making it the signing key and removing the old one once
+// tokens signed with it have expired.
+type Keyring struct {
+	signingKeyID string
+	keys         map[string]keyringKey
+	denylist     *Denylist
+	leeway       time.Duration
+}
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	TokenType TokenType `json:"token_type,omitempty"`
+}
+
+// TokenClaims are the claims of a validated access token
+type TokenClaims struct {
+	UserID    uuid.UUID
+	TokenID   string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+func NewEmptyKeyring() *Keyring {
+	return &Keyring{
+		keys: make(map[string]keyringKey),
+	}
+}
+
+// NewKeyring creates keyring of HS256 secrets
+func NewKeyring(keys map[string]string, signingKeyID string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring needs at least one key")
+	}
+
+	keyring := NewEmptyKeyring()
+	for key_id, secret := range keys {
+		err := keyring.AddHMACKey(key_id, secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := keyring.SetSigningKey(signingKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+func (k *Keyring) AddHMACKey(keyID, secret string) error {
+	if keyID == "" || secret == "" {
+		return errors.New("keyring keys need both key ID and secret")
+	}
+	return k.addKey(keyID, keyringKey{
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	})
+}
+
+// AddPrivateKeyPEM adds RSA (signed with RS256) or Ed25519 (signed with
+// EdDSA) private key. Its public key is published in JWKS.
+func (k *Keyring) AddPrivateKeyPEM(keyID string, pemData []byte) error {
+	if keyID == "" {
+		return errors.New("keyring keys need key ID")
+	}
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return fmt.Errorf("no PEM data found for key '%s'", keyID)
+	}
+
+	var private_key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private_key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private_key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return fmt.Errorf("unsupported PEM block '%s' for key '%s'", block.Type, keyID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse key '%s': %w", keyID, err)
+	}
+
+	switch typed_key := private_key.(type) {
+	case *rsa.PrivateKey:
+		if typed_key.N.BitLen() < 2048 {
+			return fmt.Errorf("RSA key '%s' must be at least 2048 bits", keyID)
+		}
+		return k.addKey(keyID, keyringKey{
+			method:    jwt.SigningMethodRS256,
+			signKey:   typed_key,
+			verifyKey: &typed_key.PublicKey,
+		})
+	case ed25519.PrivateKey:
+		return k.addKey(keyID, keyringKey{
+			method:    jwt.SigningMethodEdDSA,
+			signKey:   typed_key,
+			verifyKey: typed_key.Public(),
+		})
+	}
+	return fmt.Errorf("key '%s' must be RSA or Ed25519", keyID)
+}
+
+func (k *Keyring) addKey(keyID string, key keyringKey) error {
+	if _, exists := k.keys[keyID]; exists {
+		return fmt.Errorf("duplicate key ID '%s' in keyring", keyID)
+	}
+	k.keys[keyID] = key
+	return nil
+}
+
+// SetSigningKey chooses the key new tokens are signed with, empty keyID is
+// allowed when keyring has only one key
+func (k *Keyring) SetSigningKey(keyID string) error {
+	if keyID == "" && len(k.keys) == 1 {
+		for only_key_id := range k.keys {
+			keyID = only_key_id
+		}
+	}
+	if _, ok := k.keys[keyID]; !ok {
+		return fmt.Errorf("signing key '%s' not found in keyring", keyID)
+	}
+	k.signingKeyID = keyID
+	return nil
+}
+
+// ParseKeyringKeys parses keys in "kid1:value1,kid2:value2" format, value is
+// the secret or path to PEM file
+func ParseKeyringKeys(spec string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key_id, secret, found := strings.Cut(entry, ":")
+		if !found || key_id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid keyring entry '%s', expected kid:value", key_id)
+		}
+		if _, exists := keys[key_id]; exists {
+			return nil, fmt.Errorf("duplicate key ID '%s' in keyring", key_id)
+		}
+		keys[key_id] = secret
+	}
+	return keys, nil
+}
+
+func (k *Keyring) SigningKeyID() string {
+	return k.signingKeyID
+}
+
+// SetDenylist makes token validation reject revoked tokens
+func (k *Keyring) SetDenylist(denylist *Denylist) {
+	k.denylist = denylist
+}
+
+// SetLeeway allows this much clock difference between the server that made
+// the token and the one validating it when checking exp, nbf and iat
+func (k *Keyring) SetLeeway(leeway time.Duration) {
+	k.leeway = leeway
+}
+
+func (k *Keyring) MakeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.makeToken(TokenTypeAccess, userID, expiresIn)
+}
+
+// MakeMFAChallengeToken is given at login instead of access token when the
+// user has 2FA, it's exchanged for access token together with the code
+func (k *Keyring) MakeMFAChallengeToken(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	return k.makeToken(TokenTypeMFAChallenge, userID, expiresIn)
+}
+
+func (k *Keyring) ValidateMFAChallengeToken(tokenString string) (TokenClaims, error) {
+	return k.validateToken(TokenTypeMFAChallenge, tokenString)
+}
+
+func (k *Keyring) makeToken(tokenType TokenType, userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	signing_key, ok := k.keys[k.signingKeyID]
+	if !ok {
+		return "", errors.New("keyring has no signing key")
+	}
+	audience, ok := tokenAudiences[tokenType]
+	if !ok {
+		return "", fmt.Errorf("unknown token type '%s'", tokenType)
+	}
+
+	now := time.Now().UTC()
+	claims := jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{audience},
+			Subject:   userID.String(),
+			ID:        uuid.NewString(),
+		},
+		TokenType: tokenType,
+	}
+
+	token := jwt.NewWithClaims(signing_key.method, claims)
+	token.Header["kid"] = k.signingKeyID
+
+	result_string, err := token.SignedString(signing_key.signKey)
+	if err != nil {
+		return "", err
+	}
+
+	return result_string, nil
+}
+
+func (k *Keyring) ValidateJWT(tokenString string) (uuid.UUID, error) {
+	claims, err := k.ValidateAccessToken(tokenString)
+	return claims.UserID, err
+}
+
+func (k *Keyring) ValidateJWTWithExpiry(tokenString string) (uuid.UUID, time.Time, error) {
+	claims, err := k.ValidateAccessToken(tokenString)
+	return claims.UserID, claims.ExpiresAt, err
+}
+
+func (k *Keyring) ValidateAccessToken(tokenString string) (TokenClaims, error) {
+	return k.validateToken(TokenTypeAccess, tokenString)
+}
+
+func (k *Keyring) validateToken(tokenType TokenType, tokenString string) (TokenClaims, error) {
+	claimsStruct := jwtClaims{}
+	valid_methods := []string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	token, err := jwt.ParseWithClaims(tokenString, &claimsStruct, k.keyFunc,
+		jwt.WithValidMethods(valid_methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(k.leeway),
+	)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+
+	if claimsStruct.TokenType == "" {
+		legacy_type, found := legacyTokenIssuers[claimsStruct.Issuer]
+		if !found || legacy_type != tokenType {
+			return TokenClaims{}, errors.New("invalid issuer")
+		}
+	} else {
+		if claimsStruct.Issuer != Issuer {
+			return TokenClaims{}, errors.New("invalid issuer")
+		}
+		if claimsStruct.TokenType != tokenType {
+			return TokenClaims{}, fmt.Errorf("token type is %s, expected %s", claimsStruct.TokenType, tokenType)
+		}
+		// not with jwt.WithAudience as legacy tokens have no aud
+		if !slices.Contains(claimsStruct.Audience, tokenAudiences[tokenType]) {
+			return TokenClaims{}, errors.New("token is not for this audience")
+		}
+	}
+
+	user_id, err := token.Claims.GetSubject()
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("token did not have user ID: %w", err)
+	}
+
+	return_uuid, err := uuid.Parse(user_id)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	expires_at, err := token.Claims.GetExpirationTime()
+	if err != nil || expires_at == nil {
+		return TokenClaims{}, errors.New("token did not have expiration time")
+	}
+
+	claims := TokenClaims{
+		UserID:    return_uuid,
+		TokenID:   claimsStruct.ID,
+		ExpiresAt: expires_at.Time,
+	}
+	if claimsStruct.IssuedAt != nil {
+		claims.IssuedAt = claimsStruct.IssuedAt.Time
+	}
+
+	if k.denylist != nil {
+		revoked, err := k.denylist.IsRevoked(claims)
+		if err != nil {
+			return TokenClaims{}, fmt.Errorf("failed to check token revocation: %w", err)
+		}
+		if revoked {
+			return TokenClaims{}, errors.New("token has been revoked")
+		}
+	}
+
+	return claims, nil
+}
+
+// keyFunc picks the verification key by the kid header of the token. The
+// algorithm has to match the key so that for example a public RSA key can't
+// be used as HMAC secret.
+func (k *Keyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	key_id := DefaultKeyID
+	if kid, ok := token.Header["kid"]; ok {
+		kid_string, ok := kid.(string)
+		if !ok {
+			return nil, errors.New("invalid kid header")
+		}
+		key_id = kid_string
+	}
+
+	key, ok := k.keys[key_id]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key '%s'", key_id)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("algorithm %s does not match key '%s'", token.Method.Alg(), key_id)
+	}
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys of asymmetric keys so other services can
+// verify tokens, HMAC secrets are never included
+func (k *Keyring) JWKS() JWKSet {
+	jwks := JWKSet{
+		Keys: make([]JWK, 0),
+	}
+	for key_id, key := range k.keys {
+		switch public_key := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: key_id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public_key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public_key.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: key_id,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public_key),
+			})
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {return jwks.Keys[i].Kid < jwks.Keys[j].Kid})
+	return jwks
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"testing"
+	"time"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/ed25519"
+	"encoding/pem"
+	"encoding/base64"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	userID := uuid.New()
+
+	old_keyring, _ := NewKeyring(map[string]string{"2024": "old_secret"}, "2024")
+	transition_keyring, _ := NewKeyring(map[string]string{"2024": "old_secret", "2025": "new_secret"}, "2025")
+	new_keyring, _ := NewKeyring(map[string]string{"2025": "new_secret"}, "2025")
+	other_keyring, _ := NewKeyring(map[string]string{"2025": "other_secret"}, "2025")
+
+	old_token, _ := old_keyring.MakeJWT(userID, time.Hour)
+	new_token, _ := transition_keyring.MakeJWT(userID, time.Hour)
+	forged_token, _ := other_keyring.MakeJWT(userID, time.Hour)
+	legacy_token, _ := MakeJWT(userID, "old_secret", time.Hour)
+
+	tests := []struct {
+		name        string
+		keyring     *Keyring
+		tokenString string
+		wantErr     bool
+	}{
+		{
+			name:        "Old token during transition",
+			keyring:     transition_keyring,
+			tokenString: old_token,
+			wantErr:     false,
+		},
+		{
+			name:        "New token during transition",
+			keyring:     transition_keyring,
+			tokenString: new_token,
+			wantErr:     false,
+		},
+		{
+			name:        "New token after old key removed",
+			keyring:     new_keyring,
+			tokenString: new_token,
+			wantErr:     false,
+		},
+		{
+			name:        "Old token after old key removed",
+			keyring:     new_keyring,
+			tokenString: old_token,
+			wantErr:     true,
+		},
+		{
+			name:        "Known kid but wrong secret",
+			keyring:     new_keyring,
+			tokenString: forged_token,
+			wantErr:     true,
+		},
+		{
+			name:        "Default key not in keyring",
+			keyring:     transition_keyring,
+			tokenString: legacy_token,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUserID, err := tt.keyring.ValidateJWT(tt.tokenString)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateJWT() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && gotUserID != userID {
+				t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, userID)
+			}
+		})
+	}
+}
+
+func TestKeyringTokenWithoutKid(t *testing.T) {
+	userID := uuid.New()
+	keyring, _ := NewKeyring(map[string]string{DefaultKeyID: "secret", "2025": "new_secret"}, "2025")
+
+	claims := jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Issuer:    Issuer,
+		Subject:   userID.String(),
+	}
+	no_kid_token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+
+	gotUserID, err := keyring.ValidateJWT(no_kid_token)
+	if err != nil {
+		t.Fatalf("ValidateJWT() error = %v", err)
+	}
+	if gotUserID != userID {
+		t.Errorf("ValidateJWT() gotUserID = %v, want %v", gotUserID, userID)
+	}
+}
+
+func TestParseKeyringKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		wantKeys int
+		wantErr  bool
+	}{
+		{
+			name:     "Two keys",
+			spec:     "2024:old_secret, 2025:new:secret",
+			wantKeys: 2,
+			wantErr:  false,
+		},
+		{
+			name:    "Missing secret",
+			spec:    "2024:",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate kid",
+			spec:    "2024:a,2024:b",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeyringKeys(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseKeyringKeys() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(keys) != tt.wantKeys {
+				t.Errorf("ParseKeyringKeys() got %d keys, want %d", len(keys), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestNewKeyringSigningKey(t *testing.T) {
+	_, err := NewKeyring(map[string]string{"a": "1", "b": "2"}, "")
+	if err == nil {
+		t.Errorf("NewKeyring() expected error when signing key is not chosen among many")
+	}
+	_, err = NewKeyring(map[string]string{"a": "1"}, "c")
+	if err == nil {
+		t.Errorf("NewKeyring() expected error for unknown signing key")
+	}
+	keyring, err := NewKeyring(map[string]string{"a": "1"}, "")
+	if err != nil || keyring.SigningKeyID() != "a" {
+		t.Errorf("NewKeyring() single key should be the signing key, got %v", err)
+	}
+}
+
+func TestKeyringAsymmetricKeys(t *testing.T) {
+	userID := uuid.New()
+
+	_, ed_private, _ := ed25519.GenerateKey(rand.Reader)
+	ed_der, _ := x509.MarshalPKCS8PrivateKey(ed_private)
+	ed_pem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ed_der})
+
+	rsa_private, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsa_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsa_private)})
+
+	keyring := NewEmptyKeyring()
+	if err := keyring.AddHMACKey("hmac", "secret"); err != nil {
+		t.Fatalf("AddHMACKey() error = %v", err)
+	}
+	if err := keyring.AddPrivateKeyPEM("ed", ed_pem); err != nil {
+		t.Fatalf("AddPrivateKeyPEM() ed25519 error = %v", err)
+	}
+	if err := keyring.AddPrivateKeyPEM("rsa", rsa_pem); err != nil {
+		t.Fatalf("AddPrivateKeyPEM() rsa error = %v", err)
+	}
+
+	for _, key_id := range []string{"hmac", "ed", "rsa"} {
+		t.Run("Sign with "+key_id, func(t *testing.T) {
+			keyring.SetSigningKey(key_id)
+			token, err := keyring.MakeJWT(userID, time.Hour)
+			if err != nil {
+				t.Fatalf("MakeJWT() error = %v", err)
+			}
+			gotUserID, err := keyring.ValidateJWT(token)
+			if err != nil || gotUserID != userID {
+				t.Errorf("ValidateJWT() = %v, %v, want %v", gotUserID, err, userID)
+			}
+		})
+	}
+
+	// token signed with HS256 using the RSA public key as secret must not pass
+	public_der, _ := x509.MarshalPKIXPublicKey(&rsa_private.PublicKey)
+	confused_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		Issuer:    Issuer,
+		Subject:   userID.String(),
+	})
+	confused_token.Header["kid"] = "rsa"
+	confused_string, _ := confused_token.SignedString(public_der)
+	if _, err := keyring.ValidateJWT(confused_string); err == nil {
+		t.Errorf("ValidateJWT() accepted token with algorithm not matching the key")
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	_, ed_private, _ := ed25519.GenerateKey(rand.Reader)
+	ed_der, _ := x509.MarshalPKCS8PrivateKey(ed_private)
+
+	keyring := NewEmptyKeyring()
+	keyring.AddHMACKey("hmac", "secret")
+	keyring.AddPrivateKeyPEM("ed", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ed_der}))
+
+	jwks := keyring.JWKS()
+	if len(jwks.Keys) != 1 {
+		t.Fatalf("JWKS() got %d keys, want only the public one", len(jwks.Keys))
+	}
+	jwk := jwks.Keys[0]
+	if jwk.Kid != "ed" || jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" {
+		t.Errorf("JWKS() got unexpected key %+v", jwk)
+	}
+	public_key, _ := base64.RawURLEncoding.DecodeString(jwk.X)
+	if !ed25519.PublicKey(public_key).Equal(ed_private.Public()) {
+		t.Errorf("JWKS() public key does not match private key")
+	}
+}
+
+func TestAddPrivateKeyPEMRejectsWeakRSA(t *testing.T) {
+	rsa_private, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsa_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsa_private)})
+
+	err := NewEmptyKeyring().AddPrivateKeyPEM("weak", rsa_pem)
+	if err == nil {
+		t.Errorf("AddPrivateKeyPEM() expected error for 1024 bit RSA key")
+	}
+}
+
+func TestKeyringTokenTypes(t *testing.T) {
+	userID := uuid.New()
+	keyring, _ := NewKeyring(map[string]string{"a": "secret"}, "a")
+
+	access_token, _ := keyring.MakeJWT(userID, time.Hour)
+	challenge_token, _ := keyring.MakeMFAChallengeToken(userID, time.Minute)
+
+	if _, err := keyring.ValidateAccessToken(challenge_token); err == nil {
+		t.Errorf("ValidateAccessToken() accepted MFA challenge token")
+	}
+	if _, err := keyring.ValidateMFAChallengeToken(access_token); err == nil {
+		t.Errorf("ValidateMFAChallengeToken() accepted access token")
+	}
+	claims, err := keyring.ValidateMFAChallengeToken(challenge_token)
+	if err != nil || claims.UserID != userID {
+		t.Errorf("ValidateMFAChallengeToken() = %v, %v, want %v", claims.UserID, err, userID)
+	}
+}
+
+func TestKeyringRegisteredClaims(t *testing.T) {
+	userID := uuid.New()
+	keyring, _ := NewKeyring(map[string]string{"a": "secret"}, "a")
+	sign := func(claims jwtClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = "a"
+		signed, _ := token.SignedString([]byte("secret"))
+		return signed
+	}
+	claims := func(tokenType TokenType, audience string, notBefore time.Time) jwtClaims {
+		return jwtClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				NotBefore: jwt.NewNumericDate(notBefore),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Issuer:    Issuer,
+				Audience:  jwt.ClaimStrings{audience},
+				Subject:   userID.String(),
+			},
+			TokenType: tokenType,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		leeway  time.Duration
+		wantErr bool
+	}{
+		{
+			name:    "Valid access token",
+			token:   sign(claims(TokenTypeAccess, AudienceAPI, time.Now())),
+			wantErr: false,
+		},
+		{
+			name:    "MFA challenge token as access token",
+			token:   sign(claims(TokenTypeMFAChallenge, AudienceAPI, time.Now())),
+			wantErr: true,
+		},
+		{
+			name:    "Access token for other audience",
+			token:   sign(claims(TokenTypeAccess, AudienceLogin, time.Now())),
+			wantErr: true,
+		},
+		{
+			name:    "Not valid yet",
+			token:   sign(claims(TokenTypeAccess, AudienceAPI, time.Now().Add(20*time.Second))),
+			wantErr: true,
+		},
+		{
+			name:    "Not valid yet within leeway",
+			token:   sign(claims(TokenTypeAccess, AudienceAPI, time.Now().Add(20*time.Second))),
+			leeway:  30 * time.Second,
+			wantErr: false,
+		},
+		{
+			name: "Other issuer",
+			token: func() string {
+				other := claims(TokenTypeAccess, AudienceAPI, time.Now())
+				other.Issuer = "someone-else"
+				return sign(other)
+			}(),
+			wantErr: true,
+		},
+		{
+			name: "Without expiration",
+			token: func() string {
+				other := claims(TokenTypeAccess, AudienceAPI, time.Now())
+				other.ExpiresAt = nil
+				return sign(other)
+			}(),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring.SetLeeway(tt.leeway)
+			_, err := keyring.ValidateAccessToken(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAccessToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestKeyringLegacyMFAChallengeToken(t *testing.T) {
+	userID := uuid.New()
+	keyring, _ := NewKeyring(map[string]string{"a": "secret"}, "a")
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		Issuer:    "chirpy-mfa",
+		Subject:   userID.String(),
+	})
+	token.Header["kid"] = "a"
+	signed, _ := token.SignedString([]byte("secret"))
+
+	if _, err := keyring.ValidateAccessToken(signed); err == nil {
+		t.Errorf("ValidateAccessToken() accepted legacy MFA challenge token")
+	}
+	if _, err := keyring.ValidateMFAChallengeToken(signed); err != nil {
+		t.Errorf("ValidateMFAChallengeToken() error = %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"time"
+)
+
+// LoginThrottlePolicy decides how long logins are blocked after failed
+// attempts. After FreeAttempts failures every new failure blocks for
+// exponentially growing time up to MaxDelay, from LockoutThreshold failures on
+// the block is a lockout of LockoutDuration.
+type LoginThrottlePolicy struct {
+	FreeAttempts     int
+	BaseDelay        time.Duration
+	MaxDelay         time.Duration
+	LockoutThreshold int
+	LockoutDuration  time.Duration
+}
+
+var (
+	// AccountLoginPolicy is for failures on one email address
+	AccountLoginPolicy = LoginThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+	// IPLoginPolicy is for failures from one IP, it is looser as many users
+	// can share the address
+	IPLoginPolicy = LoginThrottlePolicy{
+		FreeAttempts:     20,
+		BaseDelay:        time.Second,
+		MaxDelay:         5 * time.Minute,
+		LockoutThreshold: 100,
+		LockoutDuration:  time.Hour,
+	}
+)
+
+// Block returns how long logins are blocked after given number of failures
+// and if the block is a lockout
+func (p LoginThrottlePolicy) Block(failures int) (time.Duration, bool) {
+	if failures >= p.LockoutThreshold {
+		return p.LockoutDuration, true
+	}
+	if failures <= p.FreeAttempts {
+		return 0, false
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay), false
+}
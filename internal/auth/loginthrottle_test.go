@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottlePolicyBlock(t *testing.T) {
+	policy := LoginThrottlePolicy{
+		FreeAttempts:     3,
+		BaseDelay:        time.Second,
+		MaxDelay:         10 * time.Second,
+		LockoutThreshold: 10,
+		LockoutDuration:  15 * time.Minute,
+	}
+
+	tests := []struct {
+		name        string
+		failures    int
+		wantDelay   time.Duration
+		wantLockout bool
+	}{
+		{
+			name:        "Free attempts",
+			failures:    3,
+			wantDelay:   0,
+			wantLockout: false,
+		},
+		{
+			name:        "First delay",
+			failures:    4,
+			wantDelay:   time.Second,
+			wantLockout: false,
+		},
+		{
+			name:        "Delay doubles",
+			failures:    6,
+			wantDelay:   4 * time.Second,
+			wantLockout: false,
+		},
+		{
+			name:        "Delay is capped",
+			failures:    9,
+			wantDelay:   10 * time.Second,
+			wantLockout: false,
+		},
+		{
+			name:        "Lockout",
+			failures:    10,
+			wantDelay:   15 * time.Minute,
+			wantLockout: true,
+		},
+		{
+			name:        "Failures after lockout lock again",
+			failures:    11,
+			wantDelay:   15 * time.Minute,
+			wantLockout: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delay, lockout := policy.Block(tt.failures)
+			if delay != tt.wantDelay || lockout != tt.wantLockout {
+				t.Errorf("Block(%d) = %v, %v, want %v, %v", tt.failures, delay, lockout, tt.wantDelay, tt.wantLockout)
+			}
+		})
+	}
+}
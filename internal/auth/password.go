@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"fmt"
+	"errors"
+	"strings"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the argon2id cost parameters, memory is in KiB
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2Params follow the OWASP recommendation for argon2id. Hashes
+// made with other parameters are rehashed on next login.
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// limits for parameters read from stored hashes so a bad row can't make
+// login use gigabytes of memory
+const (
+	maxArgon2Memory     = 1024 * 1024
+	maxArgon2Iterations = 16
+)
+
+var errInvalidHash = errors.New("invalid password hash")
+
+// HashPassword hashes with argon2id, the result is PHC string like
+// $argon2id$v=19$m=19456,t=2,p=1$salt$hash
+func HashPassword(password string) (string, error) {
+	return hashArgon2id(password, DefaultArgon2Params)
+}
+
+func hashArgon2id(password string, params Argon2Params) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Iterations,
+		params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// CheckPasswordHash checks argon2id hashes and bcrypt hashes made before
+// argon2id was the default. Empty hash, used by accounts created with OIDC
+// login, never matches.
+func CheckPasswordHash(hash, password string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return err
+		}
+		other_key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+		if subtle.ConstantTimeCompare(key, other_key) != 1 {
+			return errors.New("Passowrd not matching")
+		}
+		return nil
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		//return errors.New("Passowrd not matching")
+		return fmt.Errorf("Passowrd not matching %v", err)
+	}
+	return nil
+}
+
+// PasswordNeedsRehash tells if the hash is bcrypt or argon2id with other
+// than the default parameters. Only call it after the password was checked.
+func PasswordNeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params != DefaultArgon2Params
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, errInvalidHash
+	}
+
+	version := 0
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version '%s'", parts[2])
+	}
+
+	params := Argon2Params{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return Argon2Params{}, nil, nil, errInvalidHash
+	}
+	if params.Memory == 0 || params.Memory > maxArgon2Memory || params.Iterations == 0 || params.Iterations > maxArgon2Iterations || params.Parallelism == 0 {
+		return Argon2Params{}, nil, nil, errors.New("argon2 parameters out of range")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < 8 {
+		return Argon2Params{}, nil, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < 16 {
+		return Argon2Params{}, nil, nil, errInvalidHash
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
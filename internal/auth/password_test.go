@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordFormat(t *testing.T) {
+	hash, err := HashPassword("examplePasswordForTesting111")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("HashPassword() = %s, want argon2id PHC string", hash)
+	}
+	if PasswordNeedsRehash(hash) {
+		t.Errorf("PasswordNeedsRehash() = true for hash with default parameters")
+	}
+}
+
+func TestCheckPasswordHashFormats(t *testing.T) {
+	password := "examplePasswordForTesting111"
+	bcrypt_hash, _ := bcrypt.GenerateFromPassword([]byte(password), 10)
+	weak_hash, _ := hashArgon2id(password, Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	long_password := strings.Repeat("a", 72)
+	long_hash, _ := HashPassword(long_password + "1")
+
+	tests := []struct {
+		name       string
+		password   string
+		hash       string
+		wantErr    bool
+		wantRehash bool
+	}{
+		{
+			name:       "Legacy bcrypt hash",
+			password:   password,
+			hash:       string(bcrypt_hash),
+			wantErr:    false,
+			wantRehash: true,
+		},
+		{
+			name:       "Argon2id with old parameters",
+			password:   password,
+			hash:       weak_hash,
+			wantErr:    false,
+			wantRehash: true,
+		},
+		{
+			name:     "Empty hash never matches",
+			password: "",
+			hash:     "",
+			wantErr:  true,
+		},
+		{
+			name:     "Bytes after 72 are not ignored",
+			password: long_password + "2",
+			hash:     long_hash,
+			wantErr:  true,
+		},
+		{
+			name:     "Too expensive parameters",
+			password: password,
+			hash:     "$argon2id$v=19$m=4194304,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA$c2FsdHNhbHRzYWx0c2FsdHNhbHRzYWx0c2FsdA",
+			wantErr:  true,
+		},
+		{
+			name:     "Truncated argon2id hash",
+			password: password,
+			hash:     "$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHRzYWx0c2FsdA",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPasswordHash(tt.hash, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPasswordHash() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && PasswordNeedsRehash(tt.hash) != tt.wantRehash {
+				t.Errorf("PasswordNeedsRehash() = %v, want %v", !tt.wantRehash, tt.wantRehash)
+			}
+		})
+	}
+}
@@ -1,15 +1,9 @@
 package auth
 
-import (
-	"crypto/rand"
-	"encoding/hex"
-)
-
 func MakeRefreshToken() (string, error) {
-	token := make([]byte, 32)
-	_, err := rand.Read(token)
-	if err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(token), nil
+	return MakeOpaqueToken()
+}
+
+func HashRefreshToken(token string) string {
+	return HashOpaqueToken(token)
 }
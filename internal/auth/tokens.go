@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"strings"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MakeOpaqueToken creates random 256 bit token for refresh, password reset
+// and similar tokens that are looked up from the database
+func MakeOpaqueToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// HashOpaqueToken gives the value opaque tokens are stored and looked up
+// with, so a leaked database does not contain usable tokens. Tokens are
+// random 256 bit values so plain SHA-256 without salt is enough here.
+func HashOpaqueToken(token string) string {
+	token_hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(token_hash[:])
+}
+
+// PersonalAccessTokenPrefix makes personal access tokens easy to tell apart
+// from JWTs and to find with secret scanners
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"time"
+	"strings"
+	"testing"
+	"net/url"
+)
+
+// test vectors from RFC 6238 appendix B for SHA-1, last 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "T=59", unix: 59, want: "287082"},
+		{name: "T=1111111109", unix: 1111111109, want: "081804"},
+		{name: "T=1111111111", unix: 1111111111, want: "050471"},
+		{name: "T=1234567890", unix: 1234567890, want: "005924"},
+		{name: "T=2000000000", unix: 2000000000, want: "279037"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("TOTPCode() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("TOTPCode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, _ := GenerateTOTPSecret()
+	now := time.Unix(1700000000, 0)
+	code, _ := TOTPCode(secret, now)
+	previous_code, _ := TOTPCode(secret, now.Add(-30*time.Second))
+	old_code, _ := TOTPCode(secret, now.Add(-90*time.Second))
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "Current code", code: code, wantStep: now.Unix() / 30, wantOK: true},
+		{name: "Previous step for clock drift", code: previous_code, wantStep: now.Unix()/30 - 1, wantOK: true},
+		{name: "Too old code", code: old_code, wantOK: false},
+		{name: "Wrong length", code: "12345", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(secret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Errorf("ValidateTOTP() ok = %v, want %v", ok, tt.wantOK)
+				return
+			}
+			if ok && step != tt.wantStep {
+				t.Errorf("ValidateTOTP() step = %v, want %v", step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Chirpy", "user@example.ex", "ABCDEF")
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("TOTPURI() gave invalid URI: %v", err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Chirpy:user@example.ex" {
+		t.Errorf("TOTPURI() = %v", uri)
+	}
+	if parsed.Query().Get("secret") != "ABCDEF" || parsed.Query().Get("issuer") != "Chirpy" {
+		t.Errorf("TOTPURI() query = %v", parsed.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("GenerateRecoveryCodes() error = %v", err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 19 || seen[code] {
+			t.Errorf("GenerateRecoveryCodes() gave bad or duplicate code %v", code)
+		}
+		seen[code] = true
+	}
+	if HashRecoveryCode(codes[0]) != HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))) {
+		t.Errorf("HashRecoveryCode() should ignore case and separators")
+	}
+}
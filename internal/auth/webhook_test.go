@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWebhookVerifier(t *testing.T) {
+	now := time.Unix(1735729200, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	old_timestamp := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	verifier, err := NewWebhookVerifier([]string{"new_secret", "old_secret"}, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewWebhookVerifier() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		body      []byte
+		wantErr   error
+	}{
+		{
+			name:      "Signed with new secret",
+			timestamp: timestamp,
+			signature: SignWebhook("new_secret", timestamp, body),
+			body:      body,
+		},
+		{
+			name:      "Signed with old secret during rotation",
+			timestamp: timestamp,
+			signature: SignWebhook("old_secret", timestamp, body),
+			body:      body,
+		},
+		{
+			name:      "One of several signatures matches",
+			timestamp: timestamp,
+			signature: SignWebhook("retired_secret", timestamp, body) + ", " + SignWebhook("new_secret", timestamp, body),
+			body:      body,
+		},
+		{
+			name:      "Unknown secret",
+			timestamp: timestamp,
+			signature: SignWebhook("other_secret", timestamp, body),
+			body:      body,
+			wantErr:   ErrWebhookSignature,
+		},
+		{
+			name:      "Body changed",
+			timestamp: timestamp,
+			signature: SignWebhook("new_secret", timestamp, body),
+			body:      []byte(`{"event":"user.upgraded","data":{"user_id":"someone-else"}}`),
+			wantErr:   ErrWebhookSignature,
+		},
+		{
+			name:      "Timestamp changed",
+			timestamp: strconv.FormatInt(now.Unix()+1, 10),
+			signature: SignWebhook("new_secret", timestamp, body),
+			body:      body,
+			wantErr:   ErrWebhookSignature,
+		},
+		{
+			name:      "Replayed after tolerance",
+			timestamp: old_timestamp,
+			signature: SignWebhook("new_secret", old_timestamp, body),
+			body:      body,
+			wantErr:   ErrWebhookTimestamp,
+		},
+		{
+			name:      "No signature",
+			timestamp: timestamp,
+			signature: "",
+			body:      body,
+			wantErr:   ErrWebhookNoSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.Verify(tt.timestamp, tt.signature, tt.body, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: activitypub.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const addFollower = `-- name: AddFollower :exec
+INSERT INTO followers (user_id, actor_id, inbox, created_at, updated_at)
+VALUES ($1, $2, $3, NOW(), NOW())
+ON CONFLICT (user_id, actor_id) DO UPDATE
+SET inbox = EXCLUDED.inbox, updated_at = NOW()
+`
+
+type AddFollowerParams struct {
+	UserID  uuid.UUID
+	ActorID string
+	Inbox   string
+}
+
+func (q *Queries) AddFollower(ctx context.Context, arg AddFollowerParams) error {
+	_, err := q.db.ExecContext(ctx, addFollower, arg.UserID, arg.ActorID, arg.Inbox)
+	return err
+}
+
+const createActorKey = `-- name: CreateActorKey :one
+INSERT INTO actor_keys (user_id, created_at, public_key_pem, private_key_pem)
+VALUES ($1, NOW(), $2, $3)
+RETURNING user_id, created_at, public_key_pem, private_key_pem
+`
+
+type CreateActorKeyParams struct {
+	UserID        uuid.UUID
+	PublicKeyPem  string
+	PrivateKeyPem string
+}
+
+func (q *Queries) CreateActorKey(ctx context.Context, arg CreateActorKeyParams) (ActorKey, error) {
+	row := q.db.QueryRowContext(ctx, createActorKey, arg.UserID, arg.PublicKeyPem, arg.PrivateKeyPem)
+	var i ActorKey
+	err := row.Scan(
+		&i.UserID,
+		&i.CreatedAt,
+		&i.PublicKeyPem,
+		&i.PrivateKeyPem,
+	)
+	return i, err
+}
+
+const createRemoteNote = `-- name: CreateRemoteNote :exec
+INSERT INTO remote_notes (id, actor_id, content, published_at, received_at)
+VALUES ($1, $2, $3, $4, NOW())
+ON CONFLICT (id) DO NOTHING
+`
+
+type CreateRemoteNoteParams struct {
+	ID          string
+	ActorID     string
+	Content     string
+	PublishedAt sql.NullTime
+}
+
+func (q *Queries) CreateRemoteNote(ctx context.Context, arg CreateRemoteNoteParams) error {
+	_, err := q.db.ExecContext(ctx, createRemoteNote,
+		arg.ID,
+		arg.ActorID,
+		arg.Content,
+		arg.PublishedAt,
+	)
+	return err
+}
+
+const getActorKey = `-- name: GetActorKey :one
+SELECT user_id, created_at, public_key_pem, private_key_pem FROM actor_keys
+WHERE user_id = $1
+`
+
+func (q *Queries) GetActorKey(ctx context.Context, userID uuid.UUID) (ActorKey, error) {
+	row := q.db.QueryRowContext(ctx, getActorKey, userID)
+	var i ActorKey
+	err := row.Scan(
+		&i.UserID,
+		&i.CreatedAt,
+		&i.PublicKeyPem,
+		&i.PrivateKeyPem,
+	)
+	return i, err
+}
+
+const getFollowers = `-- name: GetFollowers :many
+SELECT user_id, actor_id, inbox, created_at, updated_at FROM followers
+WHERE user_id = $1
+order by created_at ASC
+`
+
+func (q *Queries) GetFollowers(ctx context.Context, userID uuid.UUID) ([]Follower, error) {
+	rows, err := q.db.QueryContext(ctx, getFollowers, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Follower
+	for rows.Next() {
+		var i Follower
+		if err := rows.Scan(
+			&i.UserID,
+			&i.ActorID,
+			&i.Inbox,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeFollower = `-- name: RemoveFollower :exec
+DELETE FROM followers
+WHERE user_id = $1 and actor_id = $2
+`
+
+type RemoveFollowerParams struct {
+	UserID  uuid.UUID
+	ActorID string
+}
+
+func (q *Queries) RemoveFollower(ctx context.Context, arg RemoveFollowerParams) error {
+	_, err := q.db.ExecContext(ctx, removeFollower, arg.UserID, arg.ActorID)
+	return err
+}
@@ -0,0 +1,88 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: emailverification.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const createEmailVerificationToken = `-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, created_at, expires_at, email, user_id)
+VALUES ($1, NOW(), NOW() + INTERVAL '48 hours', $2, $3)
+`
+
+type CreateEmailVerificationTokenParams struct {
+	TokenHash string
+	Email     string
+	UserID    uuid.UUID
+}
+
+func (q *Queries) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error {
+	_, err := q.db.ExecContext(ctx, createEmailVerificationToken, arg.TokenHash, arg.Email, arg.UserID)
+	return err
+}
+
+const deleteUserEmailVerificationTokens = `-- name: DeleteUserEmailVerificationTokens :exec
+DELETE FROM email_verification_tokens
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserEmailVerificationTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUserEmailVerificationTokens, userID)
+	return err
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :one
+UPDATE users
+SET updated_at = NOW(), email_verified_at = NOW()
+WHERE id = $1 and email = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role
+`
+
+type MarkEmailVerifiedParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+// the token is only valid for the email it was sent to, not for an address
+// the user changed to afterwards
+func (q *Queries) MarkEmailVerified(ctx context.Context, arg MarkEmailVerifiedParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, markEmailVerified, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const useEmailVerificationToken = `-- name: UseEmailVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE expires_at > NOW() and token_hash = $1
+RETURNING token_hash, created_at, expires_at, email, user_id
+`
+
+func (q *Queries) UseEmailVerificationToken(ctx context.Context, tokenHash string) (EmailVerificationToken, error) {
+	row := q.db.QueryRowContext(ctx, useEmailVerificationToken, tokenHash)
+	var i EmailVerificationToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.Email,
+		&i.UserID,
+	)
+	return i, err
+}
@@ -0,0 +1,247 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: loginthrottling.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const blockLogin = `-- name: BlockLogin :exec
+UPDATE login_throttles
+SET blocked_until = NOW() + $3::INTEGER * INTERVAL '1 second'
+WHERE kind = $1 and subject = $2
+`
+
+type BlockLoginParams struct {
+	Kind    string
+	Subject string
+	Seconds int32
+}
+
+func (q *Queries) BlockLogin(ctx context.Context, arg BlockLoginParams) error {
+	_, err := q.db.ExecContext(ctx, blockLogin, arg.Kind, arg.Subject, arg.Seconds)
+	return err
+}
+
+const claimLoginAttempt = `-- name: ClaimLoginAttempt :one
+INSERT INTO login_throttles (kind, subject, failures, last_failure_at, blocked_until, pending, pending_since)
+VALUES ($1, $2, 0, NOW(), NULL, 1, NOW())
+ON CONFLICT (kind, subject) DO UPDATE
+SET pending = CASE WHEN login_throttles.pending_since < NOW() - INTERVAL '1 minute' THEN 1 ELSE login_throttles.pending + 1 END,
+    pending_since = NOW()
+WHERE (login_throttles.blocked_until is NULL or login_throttles.blocked_until <= NOW())
+and (login_throttles.pending = 0
+    or login_throttles.pending_since < NOW() - INTERVAL '1 minute'
+    or (CASE WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 hour' THEN 0 ELSE login_throttles.failures END)
+        + login_throttles.pending < $3::INTEGER)
+RETURNING failures
+`
+
+type ClaimLoginAttemptParams struct {
+	Kind         string
+	Subject      string
+	FreeAttempts int32
+}
+
+// attempts in progress are counted in pending so parallel attempts can't all
+// get past the check before any failure is recorded. Within free attempts
+// they can run in parallel as long as all failing would not go over, after
+// that only one at a time. Pending count older than a minute is from a
+// request that never finished and is ignored. No row when the attempt is
+// not allowed.
+func (q *Queries) ClaimLoginAttempt(ctx context.Context, arg ClaimLoginAttemptParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, claimLoginAttempt, arg.Kind, arg.Subject, arg.FreeAttempts)
+	var failures int32
+	err := row.Scan(&failures)
+	return failures, err
+}
+
+const clearLoginThrottle = `-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE kind = $1 and subject = $2
+`
+
+type ClearLoginThrottleParams struct {
+	Kind    string
+	Subject string
+}
+
+func (q *Queries) ClearLoginThrottle(ctx context.Context, arg ClearLoginThrottleParams) error {
+	_, err := q.db.ExecContext(ctx, clearLoginThrottle, arg.Kind, arg.Subject)
+	return err
+}
+
+const createLoginLockout = `-- name: CreateLoginLockout :exec
+INSERT INTO login_lockouts (id, kind, subject, user_id, failures, created_at, locked_until, unlocked_at, unlocked_by)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW(), NOW() + $5::INTEGER * INTERVAL '1 second', NULL, NULL)
+`
+
+type CreateLoginLockoutParams struct {
+	Kind     string
+	Subject  string
+	UserID   uuid.NullUUID
+	Failures int32
+	Seconds  int32
+}
+
+func (q *Queries) CreateLoginLockout(ctx context.Context, arg CreateLoginLockoutParams) error {
+	_, err := q.db.ExecContext(ctx, createLoginLockout,
+		arg.Kind,
+		arg.Subject,
+		arg.UserID,
+		arg.Failures,
+		arg.Seconds,
+	)
+	return err
+}
+
+const deleteStaleLoginThrottles = `-- name: DeleteStaleLoginThrottles :exec
+DELETE FROM login_throttles
+WHERE last_failure_at < NOW() - INTERVAL '1 day' and (blocked_until is NULL or blocked_until < NOW())
+`
+
+func (q *Queries) DeleteStaleLoginThrottles(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteStaleLoginThrottles)
+	return err
+}
+
+const getLoginLockout = `-- name: GetLoginLockout :one
+SELECT id, kind, subject, user_id, failures, created_at, locked_until, unlocked_at, unlocked_by FROM login_lockouts
+WHERE id = $1
+`
+
+func (q *Queries) GetLoginLockout(ctx context.Context, id uuid.UUID) (LoginLockout, error) {
+	row := q.db.QueryRowContext(ctx, getLoginLockout, id)
+	var i LoginLockout
+	err := row.Scan(
+		&i.ID,
+		&i.Kind,
+		&i.Subject,
+		&i.UserID,
+		&i.Failures,
+		&i.CreatedAt,
+		&i.LockedUntil,
+		&i.UnlockedAt,
+		&i.UnlockedBy,
+	)
+	return i, err
+}
+
+const getLoginLockouts = `-- name: GetLoginLockouts :many
+SELECT id, kind, subject, user_id, failures, created_at, locked_until, unlocked_at, unlocked_by FROM login_lockouts
+ORDER BY created_at DESC
+LIMIT 100
+`
+
+func (q *Queries) GetLoginLockouts(ctx context.Context) ([]LoginLockout, error) {
+	rows, err := q.db.QueryContext(ctx, getLoginLockouts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []LoginLockout
+	for rows.Next() {
+		var i LoginLockout
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Subject,
+			&i.UserID,
+			&i.Failures,
+			&i.CreatedAt,
+			&i.LockedUntil,
+			&i.UnlockedAt,
+			&i.UnlockedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLoginRetryAfter = `-- name: GetLoginRetryAfter :one
+SELECT COALESCE(CEIL(EXTRACT(EPOCH FROM MAX(blocked_until) - NOW())), 0)::INTEGER AS retry_after
+FROM login_throttles
+WHERE ((kind = 'account' and subject = $1) or (kind = 'ip' and subject = $2))
+and blocked_until > NOW()
+`
+
+type GetLoginRetryAfterParams struct {
+	Email string
+	Ip    string
+}
+
+func (q *Queries) GetLoginRetryAfter(ctx context.Context, arg GetLoginRetryAfterParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getLoginRetryAfter, arg.Email, arg.Ip)
+	var retry_after int32
+	err := row.Scan(&retry_after)
+	return retry_after, err
+}
+
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (kind, subject, failures, last_failure_at, blocked_until)
+VALUES ($1, $2, 1, NOW(), NULL)
+ON CONFLICT (kind, subject) DO UPDATE
+SET failures = CASE WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 hour' THEN 1 ELSE login_throttles.failures + 1 END,
+    last_failure_at = NOW(),
+    pending = GREATEST(login_throttles.pending - 1, 0)
+RETURNING failures
+`
+
+type RecordLoginFailureParams struct {
+	Kind    string
+	Subject string
+}
+
+// failures are forgotten after an hour without new ones
+func (q *Queries) RecordLoginFailure(ctx context.Context, arg RecordLoginFailureParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, recordLoginFailure, arg.Kind, arg.Subject)
+	var failures int32
+	err := row.Scan(&failures)
+	return failures, err
+}
+
+const releaseLoginAttempt = `-- name: ReleaseLoginAttempt :exec
+UPDATE login_throttles
+SET pending = GREATEST(pending - 1, 0)
+WHERE kind = $1 and subject = $2
+`
+
+type ReleaseLoginAttemptParams struct {
+	Kind    string
+	Subject string
+}
+
+func (q *Queries) ReleaseLoginAttempt(ctx context.Context, arg ReleaseLoginAttemptParams) error {
+	_, err := q.db.ExecContext(ctx, releaseLoginAttempt, arg.Kind, arg.Subject)
+	return err
+}
+
+const unlockLoginLockouts = `-- name: UnlockLoginLockouts :exec
+UPDATE login_lockouts
+SET unlocked_at = NOW(), unlocked_by = $3
+WHERE kind = $1 and subject = $2 and unlocked_at is NULL
+`
+
+type UnlockLoginLockoutsParams struct {
+	Kind       string
+	Subject    string
+	UnlockedBy uuid.NullUUID
+}
+
+func (q *Queries) UnlockLoginLockouts(ctx context.Context, arg UnlockLoginLockoutsParams) error {
+	_, err := q.db.ExecContext(ctx, unlockLoginLockouts, arg.Kind, arg.Subject, arg.UnlockedBy)
+	return err
+}
@@ -11,6 +11,13 @@ import (
 	"github.com/google/uuid"
 )
 
+type ActorKey struct {
+	UserID        uuid.UUID
+	CreatedAt     time.Time
+	PublicKeyPem  string
+	PrivateKeyPem string
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -19,20 +26,145 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
-type RefreshToken struct {
-	Token     string
+type EmailVerificationToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	Email     string
+	UserID    uuid.UUID
+}
+
+type Follower struct {
+	UserID    uuid.UUID
+	ActorID   string
+	Inbox     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+}
+
+type LoginLockout struct {
+	ID          uuid.UUID
+	Kind        string
+	Subject     string
+	UserID      uuid.NullUUID
+	Failures    int32
+	CreatedAt   time.Time
+	LockedUntil time.Time
+	UnlockedAt  sql.NullTime
+	UnlockedBy  uuid.NullUUID
+}
+
+type LoginThrottle struct {
+	Kind          string
+	Subject       string
+	Failures      int32
+	LastFailureAt time.Time
+	BlockedUntil  sql.NullTime
+	Pending       int32
+	PendingSince  sql.NullTime
+}
+
+type OidcLoginState struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
 	UserID    uuid.UUID
 }
 
+type PersonalAccessToken struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+	ExpiresAt  sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type RecoveryCode struct {
+	CodeHash  string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type RefreshToken struct {
+	TokenHash  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ExpiresAt  time.Time
+	RevokedAt  sql.NullTime
+	UserID     uuid.UUID
+	FamilyID   uuid.UUID
+	UserAgent  string
+	Ip         string
+	LastUsedAt time.Time
+}
+
+type RemoteNote struct {
+	ID          string
+	ActorID     string
+	Content     string
+	PublishedAt sql.NullTime
+	ReceivedAt  time.Time
+}
+
+type RevokedAccessToken struct {
+	Jti       string
+	UserID    uuid.UUID
+	RevokedAt time.Time
+	ExpiresAt time.Time
+}
+
 type User struct {
-	ID             uuid.UUID
+	ID                  uuid.UUID
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	Email               string
+	HashedPassword      string
+	IsChirpyRed         bool
+	TokensRevokedBefore time.Time
+	EmailVerifiedAt     sql.NullTime
+	Role                string
+}
+
+type UserIdentity struct {
+	Issuer    string
+	Subject   string
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type UserTotp struct {
+	UserID         uuid.UUID
+	Secret         string
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	ConfirmedAt    sql.NullTime
+	LastUsedStep   int64
+	FailedAttempts int32
+}
+
+type WebhookEvent struct {
+	ID          uuid.UUID
+	Source      string
+	EventID     string
+	EventType   string
+	Payload     string
+	Status      string
+	Error       sql.NullString
+	Attempts    int32
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
+	ClaimedAt   sql.NullTime
 }
@@ -0,0 +1,90 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: oidc.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const createOIDCLoginState = `-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, created_at, expires_at)
+VALUES ($1, $2, $3, NOW(), NOW() + INTERVAL '10 minutes')
+`
+
+type CreateOIDCLoginStateParams struct {
+	StateHash    string
+	Nonce        string
+	CodeVerifier string
+}
+
+func (q *Queries) CreateOIDCLoginState(ctx context.Context, arg CreateOIDCLoginStateParams) error {
+	_, err := q.db.ExecContext(ctx, createOIDCLoginState, arg.StateHash, arg.Nonce, arg.CodeVerifier)
+	return err
+}
+
+const createUserIdentity = `-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, created_at)
+VALUES ($1, $2, $3, NOW())
+`
+
+type CreateUserIdentityParams struct {
+	Issuer  string
+	Subject string
+	UserID  uuid.UUID
+}
+
+func (q *Queries) CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) error {
+	_, err := q.db.ExecContext(ctx, createUserIdentity, arg.Issuer, arg.Subject, arg.UserID)
+	return err
+}
+
+const deleteExpiredOIDCLoginStates = `-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < NOW()
+`
+
+func (q *Queries) DeleteExpiredOIDCLoginStates(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, deleteExpiredOIDCLoginStates)
+	return err
+}
+
+const getUserIDByIdentity = `-- name: GetUserIDByIdentity :one
+SELECT user_id FROM user_identities
+WHERE issuer = $1 and subject = $2
+`
+
+type GetUserIDByIdentityParams struct {
+	Issuer  string
+	Subject string
+}
+
+func (q *Queries) GetUserIDByIdentity(ctx context.Context, arg GetUserIDByIdentityParams) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, getUserIDByIdentity, arg.Issuer, arg.Subject)
+	var user_id uuid.UUID
+	err := row.Scan(&user_id)
+	return user_id, err
+}
+
+const useOIDCLoginState = `-- name: UseOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE expires_at > NOW() and state_hash = $1
+RETURNING state_hash, nonce, code_verifier, created_at, expires_at
+`
+
+func (q *Queries) UseOIDCLoginState(ctx context.Context, stateHash string) (OidcLoginState, error) {
+	row := q.db.QueryRowContext(ctx, useOIDCLoginState, stateHash)
+	var i OidcLoginState
+	err := row.Scan(
+		&i.StateHash,
+		&i.Nonce,
+		&i.CodeVerifier,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
@@ -0,0 +1,135 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: personalaccesstokens.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const createPersonalAccessToken = `-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW() AT TIME ZONE 'UTC', NULL, $5, NULL)
+RETURNING id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at
+`
+
+type CreatePersonalAccessTokenParams struct {
+	UserID    uuid.UUID
+	Name      string
+	TokenHash string
+	Scopes    []string
+	ExpiresAt sql.NullTime
+}
+
+// created_at is compared to users.tokens_revoked_before so it's stored in UTC
+func (q *Queries) CreatePersonalAccessToken(ctx context.Context, arg CreatePersonalAccessTokenParams) (PersonalAccessToken, error) {
+	row := q.db.QueryRowContext(ctx, createPersonalAccessToken,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		pq.Array(arg.Scopes),
+		arg.ExpiresAt,
+	)
+	var i PersonalAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		pq.Array(&i.Scopes),
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getUserPersonalAccessTokens = `-- name: GetUserPersonalAccessTokens :many
+SELECT id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at FROM personal_access_tokens
+WHERE user_id = $1 and revoked_at is NULL and (expires_at is NULL or expires_at > NOW())
+    and created_at >= (SELECT tokens_revoked_before FROM users WHERE users.id = $1)
+ORDER BY created_at DESC
+`
+
+func (q *Queries) GetUserPersonalAccessTokens(ctx context.Context, userID uuid.UUID) ([]PersonalAccessToken, error) {
+	rows, err := q.db.QueryContext(ctx, getUserPersonalAccessTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PersonalAccessToken
+	for rows.Next() {
+		var i PersonalAccessToken
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.TokenHash,
+			pq.Array(&i.Scopes),
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokePersonalAccessToken = `-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET revoked_at = NOW()
+WHERE id = $1 and user_id = $2 and revoked_at is NULL
+`
+
+type RevokePersonalAccessTokenParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) RevokePersonalAccessToken(ctx context.Context, arg RevokePersonalAccessTokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokePersonalAccessToken, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const usePersonalAccessToken = `-- name: UsePersonalAccessToken :one
+UPDATE personal_access_tokens
+SET last_used_at = NOW()
+WHERE token_hash = $1 and revoked_at is NULL and (expires_at is NULL or expires_at > NOW())
+RETURNING id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at
+`
+
+func (q *Queries) UsePersonalAccessToken(ctx context.Context, tokenHash string) (PersonalAccessToken, error) {
+	row := q.db.QueryRowContext(ctx, usePersonalAccessToken, tokenHash)
+	var i PersonalAccessToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		pq.Array(&i.Scopes),
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
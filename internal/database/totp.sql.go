@@ -0,0 +1,168 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: totp.sql
+
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+const confirmTOTP = `-- name: ConfirmTOTP :execrows
+UPDATE user_totp
+SET confirmed_at = NOW(), last_used_step = $2
+WHERE user_id = $1 and confirmed_at is NULL
+`
+
+type ConfirmTOTPParams struct {
+	UserID       uuid.UUID
+	LastUsedStep int64
+}
+
+func (q *Queries) ConfirmTOTP(ctx context.Context, arg ConfirmTOTPParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, confirmTOTP, arg.UserID, arg.LastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (code_hash, user_id, created_at, used_at)
+VALUES ($1, $2, NOW(), NULL)
+`
+
+type CreateRecoveryCodeParams struct {
+	CodeHash string
+	UserID   uuid.UUID
+}
+
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, arg.CodeHash, arg.UserID)
+	return err
+}
+
+const deleteUserRecoveryCodes = `-- name: DeleteUserRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserRecoveryCodes(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUserRecoveryCodes, userID)
+	return err
+}
+
+const deleteUserTOTP = `-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserTOTP(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteUserTOTP, userID)
+	return err
+}
+
+const getUserTOTP = `-- name: GetUserTOTP :one
+SELECT user_id, secret, created_at, confirmed_at, last_used_step, failed_attempts FROM user_totp
+WHERE user_id = $1
+`
+
+func (q *Queries) GetUserTOTP(ctx context.Context, userID uuid.UUID) (UserTotp, error) {
+	row := q.db.QueryRowContext(ctx, getUserTOTP, userID)
+	var i UserTotp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.CreatedAt,
+		&i.ConfirmedAt,
+		&i.LastUsedStep,
+		&i.FailedAttempts,
+	)
+	return i, err
+}
+
+const incrementTOTPFailedAttempts = `-- name: IncrementTOTPFailedAttempts :one
+UPDATE user_totp
+SET failed_attempts = failed_attempts + 1
+WHERE user_id = $1
+RETURNING failed_attempts
+`
+
+func (q *Queries) IncrementTOTPFailedAttempts(ctx context.Context, userID uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, incrementTOTPFailedAttempts, userID)
+	var failed_attempts int32
+	err := row.Scan(&failed_attempts)
+	return failed_attempts, err
+}
+
+const resetTOTPFailedAttempts = `-- name: ResetTOTPFailedAttempts :exec
+UPDATE user_totp
+SET failed_attempts = 0
+WHERE user_id = $1
+`
+
+func (q *Queries) ResetTOTPFailedAttempts(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, resetTOTPFailedAttempts, userID)
+	return err
+}
+
+const upsertPendingTOTP = `-- name: UpsertPendingTOTP :exec
+INSERT INTO user_totp (user_id, secret, created_at, confirmed_at, last_used_step, failed_attempts)
+VALUES ($1, $2, NOW(), NULL, 0, 0)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0, failed_attempts = 0
+WHERE user_totp.confirmed_at is NULL
+`
+
+type UpsertPendingTOTPParams struct {
+	UserID uuid.UUID
+	Secret string
+}
+
+// enrolling again before confirming replaces the pending secret
+func (q *Queries) UpsertPendingTOTP(ctx context.Context, arg UpsertPendingTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, upsertPendingTOTP, arg.UserID, arg.Secret)
+	return err
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 and code_hash = $2 and used_at is NULL
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   uuid.UUID
+	CodeHash string
+}
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = $2, failed_attempts = 0
+WHERE user_id = $1 and last_used_step < $2
+`
+
+type UseTOTPStepParams struct {
+	UserID       uuid.UUID
+	LastUsedStep int64
+}
+
+// only later steps are accepted so the same code can't be used twice
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTOTPStep, arg.UserID, arg.LastUsedStep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
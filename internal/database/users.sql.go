@@ -14,7 +14,7 @@ import (
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role
 `
 
 type CreateUserParams struct {
@@ -32,6 +32,9 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -46,7 +49,7 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 }
 
 const findUserWithEmail = `-- name: FindUserWithEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role FROM users
 WHERE email = $1
 `
 
@@ -60,15 +63,118 @@ func (q *Queries) FindUserWithEmail(ctx context.Context, email string) (User, er
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
 
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const getUserRole = `-- name: GetUserRole :one
+SELECT role FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserRole(ctx context.Context, id uuid.UUID) (string, error) {
+	row := q.db.QueryRowContext(ctx, getUserRole, id)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
+const rehashPassword = `-- name: RehashPassword :exec
+UPDATE users
+SET hashed_password = $2
+WHERE id = $1 and hashed_password = $3
+`
+
+type RehashPasswordParams struct {
+	ID      uuid.UUID
+	NewHash string
+	OldHash string
+}
+
+// only replaces the hash it was computed from, a password change at the
+// same time wins
+func (q *Queries) RehashPassword(ctx context.Context, arg RehashPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, rehashPassword, arg.ID, arg.NewHash, arg.OldHash)
+	return err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users
+SET updated_at = NOW(), role = $2
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role
+`
+
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
+	)
+	return i, err
+}
+
+const updatePassword = `-- name: UpdatePassword :exec
+UPDATE users
+SET updated_at = NOW(), hashed_password = $2, tokens_revoked_before = NOW() AT TIME ZONE 'UTC'
+WHERE id = $1
+`
+
+type UpdatePasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updatePassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
 const updatePasswordAndEmail = `-- name: UpdatePasswordAndEmail :one
 UPDATE users
-SET updated_at = NOW(), email = $2, hashed_password = $3
+SET updated_at = NOW(), email = $2, hashed_password = $3,
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
+    tokens_revoked_before = NOW() AT TIME ZONE 'UTC'
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role
 `
 
 type UpdatePasswordAndEmailParams struct {
@@ -87,6 +193,9 @@ func (q *Queries) UpdatePasswordAndEmail(ctx context.Context, arg UpdatePassword
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -95,7 +204,7 @@ const upgradeUserChirpyRed = `-- name: UpgradeUserChirpyRed :one
 UPDATE users
 SET updated_at = NOW(), is_chirpy_red = true
 WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tokens_revoked_before, email_verified_at, role
 `
 
 func (q *Queries) UpgradeUserChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
@@ -108,6 +217,9 @@ func (q *Queries) UpgradeUserChirpyRed(ctx context.Context, id uuid.UUID) (User,
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokensRevokedBefore,
+		&i.EmailVerifiedAt,
+		&i.Role,
 	)
 	return i, err
 }
@@ -0,0 +1,188 @@
+// Code generated by sqlc. DO NOT EDIT.
+// versions:
+//   sqlc v1.29.0
+// source: webhookevents.sql
+
+package database
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/google/uuid"
+)
+
+const claimWebhookEvent = `-- name: ClaimWebhookEvent :one
+UPDATE webhook_events
+SET status = 'processing', claimed_at = NOW()
+WHERE id = $1
+AND (status IN ('received', 'failed')
+    OR ($2::BOOLEAN AND status IN ('processed', 'ignored'))
+    OR (status = 'processing' AND claimed_at < NOW() - INTERVAL '5 minutes'))
+RETURNING id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at, claimed_at
+`
+
+type ClaimWebhookEventParams struct {
+	ID     uuid.UUID
+	Replay bool
+}
+
+// only one delivery gets to process the event, replay can also take events
+// that are already done. Claims older than 5 minutes are from a delivery that
+// never finished and can be taken over
+func (q *Queries) ClaimWebhookEvent(ctx context.Context, arg ClaimWebhookEventParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, claimWebhookEvent, arg.ID, arg.Replay)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Attempts,
+		&i.ReceivedAt,
+		&i.ProcessedAt,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const getWebhookEvent = `-- name: GetWebhookEvent :one
+SELECT id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at, claimed_at FROM webhook_events
+WHERE id = $1
+`
+
+func (q *Queries) GetWebhookEvent(ctx context.Context, id uuid.UUID) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, getWebhookEvent, id)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Attempts,
+		&i.ReceivedAt,
+		&i.ProcessedAt,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const getWebhookEvents = `-- name: GetWebhookEvents :many
+SELECT id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at, claimed_at FROM webhook_events
+WHERE $1::TEXT is NULL or status = $1::TEXT
+ORDER BY received_at DESC
+LIMIT 100
+`
+
+func (q *Queries) GetWebhookEvents(ctx context.Context, status sql.NullString) ([]WebhookEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getWebhookEvents, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []WebhookEvent
+	for rows.Next() {
+		var i WebhookEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.Source,
+			&i.EventID,
+			&i.EventType,
+			&i.Payload,
+			&i.Status,
+			&i.Error,
+			&i.Attempts,
+			&i.ReceivedAt,
+			&i.ProcessedAt,
+			&i.ClaimedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const recordWebhookEvent = `-- name: RecordWebhookEvent :one
+INSERT INTO webhook_events (id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, 'received', NULL, 1, NOW(), NULL)
+ON CONFLICT (source, event_id) DO UPDATE
+SET attempts = webhook_events.attempts + 1
+RETURNING id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at, claimed_at
+`
+
+type RecordWebhookEventParams struct {
+	Source    string
+	EventID   string
+	EventType string
+	Payload   string
+}
+
+// retried delivery of the same event only bumps attempts
+func (q *Queries) RecordWebhookEvent(ctx context.Context, arg RecordWebhookEventParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, recordWebhookEvent,
+		arg.Source,
+		arg.EventID,
+		arg.EventType,
+		arg.Payload,
+	)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Attempts,
+		&i.ReceivedAt,
+		&i.ProcessedAt,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const setWebhookEventStatus = `-- name: SetWebhookEventStatus :one
+UPDATE webhook_events
+SET status = $2, error = $3, processed_at = NOW()
+WHERE id = $1
+RETURNING id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at, claimed_at
+`
+
+type SetWebhookEventStatusParams struct {
+	ID     uuid.UUID
+	Status string
+	Error  sql.NullString
+}
+
+func (q *Queries) SetWebhookEventStatus(ctx context.Context, arg SetWebhookEventStatusParams) (WebhookEvent, error) {
+	row := q.db.QueryRowContext(ctx, setWebhookEventStatus, arg.ID, arg.Status, arg.Error)
+	var i WebhookEvent
+	err := row.Scan(
+		&i.ID,
+		&i.Source,
+		&i.EventID,
+		&i.EventType,
+		&i.Payload,
+		&i.Status,
+		&i.Error,
+		&i.Attempts,
+		&i.ReceivedAt,
+		&i.ProcessedAt,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"os"
+	"log"
+	"time"
+	"context"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// FileMailer writes every message as .eml file in the directory instead of
+// sending it
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := Format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+	file_name := now.UTC().Format("20060102T150405") + "-" + uuid.NewString() + ".eml"
+	return os.WriteFile(filepath.Join(m.dir, file_name), data, 0o600)
+}
+
+// LogMailer prints messages to the log, it's the default so that local
+// development needs no mail setup
+type LogMailer struct {
+	from string
+}
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	data, err := Format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Printf("Mail not sent, logging it instead:\n%s", data)
+	return nil
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+	"bytes"
+	"errors"
+	"context"
+	"strings"
+	"net/mail"
+	"mime"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails to users, SMTPMailer is used in production and
+// FileMailer or LogMailer when testing locally without a mail server
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// ValidateAddress checks that the address is plain user@domain.tld address
+// that mail can be sent to, display names like "Name <a@b.c>" are not allowed
+func ValidateAddress(address string) error {
+	if len(address) > 254 {
+		return errors.New("email address is too long")
+	}
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return fmt.Errorf("invalid email address: %w", err)
+	}
+	if parsed.Address != address {
+		return errors.New("email address must not have display name")
+	}
+	_, domain, _ := strings.Cut(address, "@")
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return errors.New("email address domain is invalid")
+	}
+	return nil
+}
+
+// Format builds the RFC 5322 message, header values can't contain line
+// breaks so user input can't add headers
+func Format(from string, msg Message, now time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient '%s': %w", msg.To, err)
+	}
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("line break in mail header")
+		}
+	}
+
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+package mailer
+
+import (
+	"os"
+	"net"
+	"time"
+	"bufio"
+	"strings"
+	"testing"
+	"context"
+	"path/filepath"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr bool
+	}{
+		{
+			name:    "Valid message",
+			msg:     Message{To: "user@example.ex", Subject: "Hello", Body: "line1\nline2"},
+			wantErr: false,
+		},
+		{
+			name:    "Header injection in subject",
+			msg:     Message{To: "user@example.ex", Subject: "Hello\r\nBcc: other@example.ex", Body: "body"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid recipient",
+			msg:     Message{To: "not an address", Subject: "Hello", Body: "body"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Format("chirpy@example.ex", tt.msg, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Format() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !strings.Contains(string(data), "\r\n\r\nline1\r\nline2") {
+				t.Errorf("Format() body not CRLF separated: %q", data)
+			}
+		})
+	}
+}
+
+func TestValidateAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr bool
+	}{
+		{name: "Valid", address: "user.name+tag@example.ex", wantErr: false},
+		{name: "Empty", address: "", wantErr: true},
+		{name: "No at sign", address: "example.ex", wantErr: true},
+		{name: "Display name", address: "User <user@example.ex>", wantErr: true},
+		{name: "Domain without dot", address: "user@localhost", wantErr: true},
+		{name: "Domain ends with dot", address: "user@example.", wantErr: true},
+		{name: "Whitespace", address: " user@example.ex", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateAddress(tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := NewFileMailer(filepath.Join(dir, "mail"), "chirpy@example.ex")
+	if err != nil {
+		t.Fatalf("NewFileMailer() error = %v", err)
+	}
+	err = mailer.Send(context.Background(), Message{To: "user@example.ex", Subject: "Reset", Body: "token"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "mail", "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Send() wrote %d files, want 1", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(data), "To: user@example.ex") {
+		t.Errorf("Send() wrote unexpected message %q", data)
+	}
+}
+
+// TestSMTPMailer runs minimal SMTP server that accepts one message
+func TestSMTPMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		data := strings.Builder{}
+		in_data := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if in_data {
+				if line == ".\r\n" {
+					in_data = false
+					received <- data.String()
+					conn.Write([]byte("250 OK\r\n"))
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+			switch {
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case strings.HasPrefix(line, "DATA"):
+				in_data = true
+				conn.Write([]byte("354 go ahead\r\n"))
+			case strings.HasPrefix(line, "QUIT"):
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+
+	mailer := NewSMTPMailer(listener.Addr().String(), "chirpy@example.ex", "", "")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = mailer.Send(ctx, Message{To: "user@example.ex", Subject: "Reset", Body: "token"})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := <-received; !strings.Contains(got, "Subject: Reset") {
+		t.Errorf("SMTP server got unexpected message %q", got)
+	}
+}
@@ -0,0 +1,55 @@
+package mailer
+
+import (
+	"net"
+	"time"
+	"context"
+	"net/smtp"
+)
+
+// SMTPMailer sends through SMTP server, STARTTLS is used when the server
+// supports it and authentication only happens over TLS or to localhost
+type SMTPMailer struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	return &SMTPMailer{
+		addr:     addr,
+		from:     from,
+		username: username,
+		password: password,
+	}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	data, err := Format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		host, _, err := net.SplitHostPort(m.addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.username, m.password, host)
+	}
+
+	// smtp.SendMail has no context support, so it runs in the background and
+	// is left to finish on its own if the context is done
+	result := make(chan error, 1)
+	go func() {
+		result <- smtp.SendMail(m.addr, auth, m.from, []string{msg.To}, data)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,296 @@
+package oidc
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"errors"
+	"context"
+	"strings"
+	"net/url"
+	"net/http"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/base64"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDToken has the claims of verified ID token chirpy uses
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string      `json:"nonce"`
+	AuthorizedParty string      `json:"azp"`
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"`
+}
+
+// Client does authorization code flow with PKCE against one OpenID Connect
+// provider. The discovery document and signing keys are fetched on first use
+// so the server starts even when the provider is down.
+type Client struct {
+	config        Config
+	httpClient    *http.Client
+	mu            sync.Mutex
+	discovery     *discoveryDocument
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+func NewClient(config Config) *Client {
+	return &Client{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GenerateRandom gives random value for state, nonce and PKCE code verifier
+func GenerateRandom() (string, error) {
+	random_bytes := make([]byte, 32)
+	_, err := rand.Read(random_bytes)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random_bytes), nil
+}
+
+// CodeChallenge is the S256 PKCE challenge of the verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	discovery, err := c.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", c.config.ClientID)
+	query.Set("redirect_uri", c.config.RedirectURL)
+	query.Set("scope", "openid email")
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(codeVerifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades the authorization code for tokens and returns the raw ID token
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	discovery, err := c.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.config.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", c.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, RFC 6749 wants the values form encoded first
+	req.SetBasicAuth(url.QueryEscape(c.config.ClientID), url.QueryEscape(c.config.ClientSecret))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	token_response := struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token_response)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, token_response.Error, token_response.ErrorDescription)
+	}
+	if token_response.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return token_response.IDToken, nil
+}
+
+// VerifyIDToken checks signature with the provider's JWKS, issuer, audience,
+// expiry and that the nonce is the one sent in the authorization request
+func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (IDToken, error) {
+	claims := idTokenClaims{}
+	valid_methods := []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		key_id, _ := token.Header["kid"].(string)
+		return c.getKey(ctx, key_id)
+	},
+		jwt.WithValidMethods(valid_methods),
+		jwt.WithIssuer(c.config.Issuer),
+		jwt.WithAudience(c.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return IDToken{}, err
+	}
+
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return IDToken{}, errors.New("ID token nonce does not match")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != c.config.ClientID {
+		return IDToken{}, errors.New("ID token azp does not match client ID")
+	}
+	if claims.Subject == "" {
+		return IDToken{}, errors.New("ID token has no subject")
+	}
+
+	// some providers send email_verified as string
+	email_verified := false
+	switch value := claims.EmailVerified.(type) {
+	case bool:
+		email_verified = value
+	case string:
+		email_verified = value == "true"
+	}
+
+	return IDToken{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: email_verified,
+	}, nil
+}
+
+func (c *Client) getDiscovery(ctx context.Context) (*discoveryDocument, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	discovery := &discoveryDocument{}
+	err := c.getJSON(ctx, strings.TrimSuffix(c.config.Issuer, "/")+"/.well-known/openid-configuration", discovery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if discovery.Issuer != c.config.Issuer {
+		return nil, fmt.Errorf("discovery document issuer '%s' does not match '%s'", discovery.Issuer, c.config.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document is missing endpoints")
+	}
+	c.discovery = discovery
+	return discovery, nil
+}
+
+// getKey finds the signing key by kid. Unknown kid refreshes the JWKS, at
+// most once a minute, so provider key rotation is picked up.
+func (c *Client) getKey(ctx context.Context, keyID string) (interface{}, error) {
+	discovery, err := c.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, found := c.findKey(keyID)
+	if found {
+		return key, nil
+	}
+	if time.Since(c.keysFetchedAt) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+	}
+
+	jwks := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	err = c.getJSON(ctx, discovery.JWKSURI, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	c.keysFetchedAt = time.Now()
+	c.keys = make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public_key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		c.keys[jwk.Kid] = public_key
+	}
+
+	key, found = c.findKey(keyID)
+	if !found {
+		return nil, fmt.Errorf("unknown signing key '%s'", keyID)
+	}
+	return key, nil
+}
+
+// findKey needs the lock, token without kid is only accepted when there is one key
+func (c *Client) findKey(keyID string) (interface{}, bool) {
+	if keyID == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[keyID]
+	return key, ok
+}
+
+func (c *Client) getJSON(ctx context.Context, target string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", target, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,268 @@
+package oidc
+
+import (
+	"sync"
+	"time"
+	"testing"
+	"context"
+	"net/url"
+	"net/http"
+	"net/http/httptest"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/base64"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type authorizationRequest struct {
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// mockProvider is a local OpenID Connect provider that logs in everyone as
+// the configured subject
+type mockProvider struct {
+	server       *httptest.Server
+	key          *rsa.PrivateKey
+	keyID        string
+	clientID     string
+	clientSecret string
+	subject      string
+	email        string
+	audience     string
+	mu           sync.Mutex
+	codes        map[string]authorizationRequest
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	provider := &mockProvider{
+		key:          key,
+		keyID:        "mock-key",
+		clientID:     "chirpy",
+		clientSecret: "client secret",
+		subject:      "user-1",
+		email:        "user@example.ex",
+		codes:        make(map[string]authorizationRequest),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discoveryDocument{
+			Issuer:                provider.server.URL,
+			AuthorizationEndpoint: provider.server.URL + "/authorize",
+			TokenEndpoint:         provider.server.URL + "/token",
+			JWKSURI:               provider.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jsonWebKey{"keys": {{
+			Kty: "RSA",
+			Kid: provider.keyID,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(provider.key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("client_id") != provider.clientID || query.Get("code_challenge_method") != "S256" {
+			w.WriteHeader(400)
+			return
+		}
+		code, _ := GenerateRandom()
+		provider.mu.Lock()
+		provider.codes[code] = authorizationRequest{
+			nonce:         query.Get("nonce"),
+			codeChallenge: query.Get("code_challenge"),
+			redirectURI:   query.Get("redirect_uri"),
+		}
+		provider.mu.Unlock()
+		http.Redirect(w, r, query.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(query.Get("state")), http.StatusFound)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		client_id, client_secret, ok := r.BasicAuth()
+		if !ok || client_id != provider.clientID || client_secret != url.QueryEscape(provider.clientSecret) {
+			w.WriteHeader(401)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		r.ParseForm()
+		provider.mu.Lock()
+		request, found := provider.codes[r.Form.Get("code")]
+		delete(provider.codes, r.Form.Get("code"))
+		provider.mu.Unlock()
+		if !found || request.redirectURI != r.Form.Get("redirect_uri") || CodeChallenge(r.Form.Get("code_verifier")) != request.codeChallenge {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		id_token := provider.signIDToken(t, request.nonce, provider.key, provider.keyID)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": id_token, "token_type": "Bearer"})
+	})
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func (p *mockProvider) signIDToken(t *testing.T, nonce string, key *rsa.PrivateKey, keyID string) string {
+	audience := p.audience
+	if audience == "" {
+		audience = p.clientID
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.server.URL,
+		"sub":            p.subject,
+		"aud":            audience,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          p.email,
+		"email_verified": true,
+	})
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed to sign ID token: %v", err)
+	}
+	return signed
+}
+
+// authorize does the browser part of the flow and returns the code
+func authorize(t *testing.T, client *Client, nonce, verifier string) string {
+	auth_url, err := client.AuthCodeURL(context.Background(), "state-1", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+	no_redirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := no_redirect.Get(auth_url)
+	if err != nil {
+		t.Fatalf("authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	location, _ := url.Parse(resp.Header.Get("Location"))
+	if location.Query().Get("state") != "state-1" {
+		t.Fatalf("provider did not return the state, got %v", location)
+	}
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	provider := newMockProvider(t)
+	client := NewClient(Config{
+		Issuer:       provider.server.URL,
+		ClientID:     provider.clientID,
+		ClientSecret: provider.clientSecret,
+		RedirectURL:  "http://chirpy.test/api/auth/oidc/callback",
+	})
+	nonce, _ := GenerateRandom()
+	verifier, _ := GenerateRandom()
+
+	code := authorize(t, client, nonce, verifier)
+	raw_id_token, err := client.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	id_token, err := client.VerifyIDToken(context.Background(), raw_id_token, nonce)
+	if err != nil {
+		t.Fatalf("VerifyIDToken() error = %v", err)
+	}
+	if id_token.Subject != provider.subject || id_token.Email != provider.email || !id_token.EmailVerified || id_token.Issuer != provider.server.URL {
+		t.Errorf("VerifyIDToken() = %+v", id_token)
+	}
+
+	// codes are single use
+	_, err = client.Exchange(context.Background(), code, verifier)
+	if err == nil {
+		t.Errorf("Exchange() accepted used code")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	provider := newMockProvider(t)
+	client := NewClient(Config{
+		Issuer:       provider.server.URL,
+		ClientID:     provider.clientID,
+		ClientSecret: provider.clientSecret,
+		RedirectURL:  "http://chirpy.test/api/auth/oidc/callback",
+	})
+	verifier, _ := GenerateRandom()
+	other_verifier, _ := GenerateRandom()
+
+	code := authorize(t, client, "nonce", verifier)
+	_, err := client.Exchange(context.Background(), code, other_verifier)
+	if err == nil {
+		t.Errorf("Exchange() accepted wrong PKCE verifier")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	provider := newMockProvider(t)
+	client := NewClient(Config{
+		Issuer:       provider.server.URL,
+		ClientID:     provider.clientID,
+		ClientSecret: provider.clientSecret,
+		RedirectURL:  "http://chirpy.test/api/auth/oidc/callback",
+	})
+	other_key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	tests := []struct {
+		name    string
+		token   func() string
+		nonce   string
+		wantErr bool
+	}{
+		{
+			name:    "Valid token",
+			token:   func() string { return provider.signIDToken(t, "nonce-1", provider.key, provider.keyID) },
+			nonce:   "nonce-1",
+			wantErr: false,
+		},
+		{
+			name:    "Wrong nonce",
+			token:   func() string { return provider.signIDToken(t, "nonce-1", provider.key, provider.keyID) },
+			nonce:   "nonce-2",
+			wantErr: true,
+		},
+		{
+			name:    "Signed with unknown key",
+			token:   func() string { return provider.signIDToken(t, "nonce-1", other_key, "other-key") },
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name:    "Known kid but wrong key",
+			token:   func() string { return provider.signIDToken(t, "nonce-1", other_key, provider.keyID) },
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+		{
+			name: "Issued for another client",
+			token: func() string {
+				provider.audience = "other-client"
+				defer func() { provider.audience = "" }()
+				return provider.signIDToken(t, "nonce-1", provider.key, provider.keyID)
+			},
+			nonce:   "nonce-1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.VerifyIDToken(context.Background(), tt.token(), tt.nonce)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
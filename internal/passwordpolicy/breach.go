@@ -0,0 +1,61 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"time"
+	"bufio"
+	"context"
+	"strings"
+	"net/http"
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// RangeClient does k-anonymity lookups against a server with the Pwned
+// Passwords range API, GET <url>/range/<first 5 hex of SHA-1> returning
+// SUFFIX:COUNT lines. Only the prefix leaves the server so it is meant for a
+// locally hosted copy of the corpus.
+type RangeClient struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewRangeClient(baseURL string) *RangeClient {
+	return &RangeClient{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (c *RangeClient) IsBreached(ctx context.Context, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/range/"+prefix, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("breached password range lookup returned %d", resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line_suffix, count, found := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !found || !strings.EqualFold(line_suffix, suffix) {
+			continue
+		}
+		// padded responses have entries with count 0
+		return strings.TrimLeft(count, "0") != "", nil
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,112 @@
+package passwordpolicy
+
+import (
+	"os"
+	"fmt"
+	"bufio"
+	"context"
+	"strings"
+	"unicode/utf8"
+)
+
+// codes of Violation, clients can use them to show their own messages
+const (
+	CodeTooShort = "too_short"
+	CodeTooLong  = "too_long"
+	CodeBanned   = "banned"
+	CodeBreached = "breached"
+)
+
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BreachChecker tells if the password is in a known data breach
+type BreachChecker interface {
+	IsBreached(ctx context.Context, password string) (bool, error)
+}
+
+// Policy checks new passwords. Length is counted in characters, not bytes.
+type Policy struct {
+	MinLength int
+	MaxLength int
+	banned    map[string]struct{}
+	breached  BreachChecker
+}
+
+func New(minLength, maxLength int) *Policy {
+	return &Policy{
+		MinLength: minLength,
+		MaxLength: maxLength,
+		banned:    make(map[string]struct{}),
+	}
+}
+
+// LoadBannedFile adds passwords from a file with one password per line, empty
+// lines and lines starting with # are skipped. Matching ignores case.
+func (p *Policy) LoadBannedFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.banned[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+func (p *Policy) SetBreachChecker(checker BreachChecker) {
+	p.breached = checker
+}
+
+// Validate returns all violations, empty result means the password is fine.
+// Error is only returned when the breach check fails, the other checks are
+// still done and returned with it.
+func (p *Policy) Validate(ctx context.Context, password string) ([]Violation, error) {
+	violations := []Violation{}
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("Password can be at most %d characters", p.MaxLength),
+		})
+		// no point sending very long password to breach check
+		return violations, nil
+	}
+	if _, found := p.banned[strings.ToLower(password)]; found {
+		violations = append(violations, Violation{
+			Code:    CodeBanned,
+			Message: "Password is too common",
+		})
+		return violations, nil
+	}
+
+	if p.breached == nil || password == "" {
+		return violations, nil
+	}
+	breached, err := p.breached.IsBreached(ctx, password)
+	if err != nil {
+		return violations, err
+	}
+	if breached {
+		violations = append(violations, Violation{
+			Code:    CodeBreached,
+			Message: "Password has appeared in a data breach",
+		})
+	}
+	return violations, nil
+}
@@ -0,0 +1,126 @@
+package passwordpolicy
+
+import (
+	"os"
+	"errors"
+	"testing"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+)
+
+type fakeBreachChecker struct {
+	breached map[string]bool
+	err      error
+}
+
+func (f fakeBreachChecker) IsBreached(ctx context.Context, password string) (bool, error) {
+	return f.breached[password], f.err
+}
+
+func TestValidate(t *testing.T) {
+	banned_file := filepath.Join(t.TempDir(), "banned.txt")
+	os.WriteFile(banned_file, []byte("# common passwords\nPassword123\n\nqwertyuiop\n"), 0600)
+	policy := New(8, 20)
+	err := policy.LoadBannedFile(banned_file)
+	if err != nil {
+		t.Fatalf("LoadBannedFile() error = %v", err)
+	}
+	policy.SetBreachChecker(fakeBreachChecker{breached: map[string]bool{"correcthorse": true}})
+
+	tests := []struct {
+		name      string
+		password  string
+		wantCodes []string
+	}{
+		{
+			name:      "Valid password",
+			password:  "battery staple",
+			wantCodes: []string{},
+		},
+		{
+			name:      "Empty password",
+			password:  "",
+			wantCodes: []string{CodeTooShort},
+		},
+		{
+			name:      "Length is counted in characters",
+			password:  "ääääääää",
+			wantCodes: []string{},
+		},
+		{
+			name:      "Too long",
+			password:  "aaaaaaaaaaaaaaaaaaaaa",
+			wantCodes: []string{CodeTooLong},
+		},
+		{
+			name:      "Banned ignores case",
+			password:  "password123",
+			wantCodes: []string{CodeBanned},
+		},
+		{
+			name:      "Breached",
+			password:  "correcthorse",
+			wantCodes: []string{CodeBreached},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations, err := policy.Validate(context.Background(), tt.password)
+			if err != nil {
+				t.Fatalf("Validate() error = %v", err)
+			}
+			if len(violations) != len(tt.wantCodes) {
+				t.Fatalf("Validate() = %v, want codes %v", violations, tt.wantCodes)
+			}
+			for i, violation := range violations {
+				if violation.Code != tt.wantCodes[i] {
+					t.Errorf("Validate() = %v, want codes %v", violations, tt.wantCodes)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateBreachCheckError(t *testing.T) {
+	policy := New(8, 20)
+	policy.SetBreachChecker(fakeBreachChecker{err: errors.New("corpus down")})
+
+	violations, err := policy.Validate(context.Background(), "short")
+	if err == nil {
+		t.Errorf("Validate() expected breach check error")
+	}
+	if len(violations) != 1 || violations[0].Code != CodeTooShort {
+		t.Errorf("Validate() = %v, want the other violations with the error", violations)
+	}
+}
+
+func TestRangeClient(t *testing.T) {
+	requested_prefix := ""
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested_prefix = r.URL.Path
+		w.Write([]byte("0018A45C4D1DEF81644B54AB7F969B88D65:0\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n011053FD0102E94D6AE2F8B83D76FAF94F6:1\r\n"))
+	}))
+	defer server.Close()
+	client := NewRangeClient(server.URL + "/")
+
+	breached, err := client.IsBreached(context.Background(), "password")
+	if err != nil || !breached {
+		t.Errorf("IsBreached(password) = %v, %v, want true", breached, err)
+	}
+	if requested_prefix != "/range/5BAA6" {
+		t.Errorf("requested %s, want /range/5BAA6", requested_prefix)
+	}
+
+	// padding entry with count 0 is not a match
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:0\r\n"))
+	})
+	breached, err = client.IsBreached(context.Background(), "password")
+	if err != nil || breached {
+		t.Errorf("IsBreached() = %v, %v, want false", breached, err)
+	}
+}
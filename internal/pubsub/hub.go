@@ -0,0 +1,171 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"strconv"
+	"strings"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+type EventType string
+
+const (
+	EventChirpCreated EventType = "chirp.created"
+	EventChirpDeleted EventType = "chirp.deleted"
+	EventUserUpgraded EventType = "user.upgraded"
+	// sent by Resume when the missed events can't be replayed
+	EventResync EventType = "resync"
+)
+
+const defaultBacklogSize = 256
+const subscriberBufferSize = 32
+
+type Event struct {
+	ID      uint64          `json:"id"`
+	Type    EventType       `json:"type"`
+	ChirpID uuid.UUID       `json:"chirp_id"`
+	UserID  uuid.UUID       `json:"user_id"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type Filter func(Event) bool
+
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	hub    *Hub
+}
+
+// Hub fans out events to in-process subscribers and keeps a short backlog
+// so reconnecting clients can resume from the last event they have seen.
+// IDs are only meaningful to the hub that gave them, events from other
+// instances get new IDs here. That's why event IDs given to clients carry the
+// hub's epoch, a random value picked on start, so an ID from another instance
+// or from before a restart is recognised and not compared with ours.
+type Hub struct {
+	mu          sync.Mutex
+	epoch       string
+	nextID      uint64
+	backlog     []Event
+	backlogSize int
+	subscribers map[*Subscription]struct{}
+}
+
+func NewHub() *Hub {
+	epoch := make([]byte, 8)
+	rand.Read(epoch)
+	return &Hub{
+		epoch:       hex.EncodeToString(epoch),
+		backlogSize: defaultBacklogSize,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+func (h *Hub) Publish(event Event) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.nextID++
+	event.ID = h.nextID
+
+	h.backlog = append(h.backlog, event)
+	if len(h.backlog) > h.backlogSize {
+		h.backlog = h.backlog[len(h.backlog)-h.backlogSize:]
+	}
+
+	for sub := range h.subscribers {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			// subscriber is not keeping up, drop it rather than block everyone else
+			h.removeLocked(sub)
+		}
+	}
+
+	return event
+}
+
+// Subscribe registers a new subscriber. Events from the backlog newer than
+// lastEventID that pass the filter are returned so the caller can replay them
+// before reading from the subscription channel. Pass 0 to skip the replay.
+func (h *Hub) Subscribe(filter Filter, lastEventID uint64) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.subscribeLocked(filter, lastEventID)
+}
+
+func (h *Hub) subscribeLocked(filter Filter, lastEventID uint64) (*Subscription, []Event) {
+	ch := make(chan Event, subscriberBufferSize)
+	sub := &Subscription{
+		C:      ch,
+		ch:     ch,
+		filter: filter,
+		hub:    h,
+	}
+
+	missed := make([]Event, 0)
+	if lastEventID != 0 {
+		for _, event := range h.backlog {
+			if event.ID <= lastEventID {
+				continue
+			}
+			if filter != nil && !filter(event) {
+				continue
+			}
+			missed = append(missed, event)
+		}
+	}
+
+	h.subscribers[sub] = struct{}{}
+	return sub, missed
+}
+
+// EventID is the ID of the event as given to clients, "<epoch>-<id>".
+func (h *Hub) EventID(event Event) string {
+	return fmt.Sprintf("%s-%d", h.epoch, event.ID)
+}
+
+// Resume is Subscribe for a client that comes back with the last event ID it
+// got from EventID. When the ID is not from this hub, or is so old that newer
+// events already left the backlog, the missed events can't be replayed and a
+// single EventResync event is returned instead, the client has to reload
+// everything. Its ID is the latest event so the client can resume from it.
+func (h *Hub) Resume(filter Filter, lastEventID string) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	epoch, id, found := strings.Cut(lastEventID, "-")
+	parsed_id, err := strconv.ParseUint(id, 10, 64)
+	if found && err == nil && epoch == h.epoch && parsed_id != 0 && parsed_id <= h.nextID {
+		if len(h.backlog) == 0 || parsed_id >= h.backlog[0].ID-1 {
+			return h.subscribeLocked(filter, parsed_id)
+		}
+	}
+
+	sub, _ := h.subscribeLocked(filter, 0)
+	resync := Event{ID: h.nextID, Type: EventResync, Data: json.RawMessage("{}")}
+	return sub, []Event{resync}
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.removeLocked(s)
+}
+
+func (h *Hub) removeLocked(sub *Subscription) {
+	if _, ok := h.subscribers[sub]; !ok {
+		return
+	}
+	delete(h.subscribers, sub)
+	close(sub.ch)
+}
@@ -0,0 +1,137 @@
+package pubsub
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHubPublishAndFilter(t *testing.T) {
+	hub := NewHub()
+	author := uuid.New()
+
+	all_sub, _ := hub.Subscribe(nil, 0)
+	defer all_sub.Close()
+	author_sub, _ := hub.Subscribe(func(e Event) bool { return e.UserID == author }, 0)
+	defer author_sub.Close()
+
+	hub.Publish(Event{Type: EventChirpCreated, UserID: uuid.New()})
+	published := hub.Publish(Event{Type: EventChirpCreated, UserID: author})
+
+	if got := len(all_sub.C); got != 2 {
+		t.Errorf("unfiltered subscriber got %d events, want 2", got)
+	}
+	if got := len(author_sub.C); got != 1 {
+		t.Fatalf("filtered subscriber got %d events, want 1", got)
+	}
+	event := <-author_sub.C
+	if event.ID != published.ID {
+		t.Errorf("filtered subscriber got event %d, want %d", event.ID, published.ID)
+	}
+}
+
+func TestHubResumeFromLastEventID(t *testing.T) {
+	hub := NewHub()
+
+	first := hub.Publish(Event{Type: EventChirpCreated})
+	second := hub.Publish(Event{Type: EventChirpCreated})
+	third := hub.Publish(Event{Type: EventChirpDeleted})
+
+	tests := []struct {
+		name        string
+		lastEventID uint64
+		wantIDs     []uint64
+	}{
+		{
+			name:        "No resume",
+			lastEventID: 0,
+			wantIDs:     []uint64{},
+		},
+		{
+			name:        "Resume after first",
+			lastEventID: first.ID,
+			wantIDs:     []uint64{second.ID, third.ID},
+		},
+		{
+			name:        "Already up to date",
+			lastEventID: third.ID,
+			wantIDs:     []uint64{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed := hub.Subscribe(nil, tt.lastEventID)
+			defer sub.Close()
+			if len(missed) != len(tt.wantIDs) {
+				t.Fatalf("Subscribe() missed = %d events, want %d", len(missed), len(tt.wantIDs))
+			}
+			for i, event := range missed {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("Subscribe() missed[%d] = %d, want %d", i, event.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHubResumeFromEventID(t *testing.T) {
+	hub := NewHub()
+	hub.backlogSize = 2
+
+	first := hub.Publish(Event{Type: EventChirpCreated})
+	second := hub.Publish(Event{Type: EventChirpCreated})
+	third := hub.Publish(Event{Type: EventChirpDeleted})
+	fourth := hub.Publish(Event{Type: EventChirpDeleted})
+	other_hub := NewHub()
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		wantIDs     []uint64
+		wantResync  bool
+	}{
+		{"Resume after second", hub.EventID(second), []uint64{third.ID, fourth.ID}, false},
+		{"Already up to date", hub.EventID(fourth), []uint64{}, false},
+		{"Missed events left the backlog", hub.EventID(first), nil, true},
+		{"ID from another hub", other_hub.EventID(second), nil, true},
+		{"ID without epoch", strconv.FormatUint(second.ID, 10), nil, true},
+		{"ID from the future", hub.EventID(Event{ID: fourth.ID + 1}), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, missed := hub.Resume(nil, tt.lastEventID)
+			defer sub.Close()
+			if tt.wantResync {
+				if len(missed) != 1 || missed[0].Type != EventResync || missed[0].ID != fourth.ID {
+					t.Fatalf("Resume() missed = %+v, want a resync at %d", missed, fourth.ID)
+				}
+				return
+			}
+			if len(missed) != len(tt.wantIDs) {
+				t.Fatalf("Resume() missed = %d events, want %d", len(missed), len(tt.wantIDs))
+			}
+			for i, event := range missed {
+				if event.ID != tt.wantIDs[i] {
+					t.Errorf("Resume() missed[%d] = %d, want %d", i, event.ID, tt.wantIDs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	hub := NewHub()
+	sub, _ := hub.Subscribe(nil, 0)
+
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		hub.Publish(Event{Type: EventChirpCreated})
+	}
+
+	for range sub.C {
+	}
+	// closing an already dropped subscription must not panic
+	sub.Close()
+}
@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"log"
+	"time"
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	notifyChannel        = "chirpy_events"
+	notifyPayloadLimit   = 8000
+	notifyPublishTimeout = 5 * time.Second
+	listenerMinReconnect = 1 * time.Second
+	listenerMaxReconnect = 1 * time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+type Publisher interface {
+	Publish(Event) Event
+}
+
+type notifyPayload struct {
+	Origin uuid.UUID `json:"origin"`
+	Event  Event     `json:"event"`
+}
+
+// PGBridge delivers events to the local hub and shares them with other chirpy
+// instances through Postgres LISTEN/NOTIFY. Events coming back from the
+// database that this instance sent itself are ignored since they were
+// already delivered locally. Each hub numbers the events itself, a client
+// resuming on another instance is told to resync by Hub.Resume.
+type PGBridge struct {
+	hub        *Hub
+	db         *sql.DB
+	listener   *pq.Listener
+	instanceID uuid.UUID
+}
+
+func NewPGBridge(hub *Hub, db *sql.DB, dbURL string) (*PGBridge, error) {
+	listener := pq.NewListener(dbURL, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Event listener connection problem: %s", err)
+		}
+	})
+	err := listener.Listen(notifyChannel)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return &PGBridge{
+		hub:        hub,
+		db:         db,
+		listener:   listener,
+		instanceID: uuid.New(),
+	}, nil
+}
+
+func (b *PGBridge) Publish(event Event) Event {
+	event = b.hub.Publish(event)
+
+	payload, err := json.Marshal(notifyPayload{
+		Origin: b.instanceID,
+		Event:  event,
+	})
+	if err != nil {
+		log.Printf("Error marshalling event JSON: %s", err)
+		return event
+	}
+	if len(payload) > notifyPayloadLimit {
+		log.Printf("Event %d too large to share with other instances: %d bytes", event.ID, len(payload))
+		return event
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyPublishTimeout)
+	defer cancel()
+	_, err = b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", notifyChannel, string(payload))
+	if err != nil {
+		log.Printf("Error sending event notification: %s", err)
+	}
+	return event
+}
+
+// Run rebroadcasts events from other instances to local subscribers until ctx is done
+func (b *PGBridge) Run(ctx context.Context) {
+	ping_ticker := time.NewTicker(listenerPingInterval)
+	defer ping_ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-b.listener.Notify:
+			if notification == nil {
+				// listener reconnected, anything sent while it was down is lost
+				log.Printf("Event listener reconnected, events from other instances may have been missed")
+				continue
+			}
+			b.handleNotification(notification.Extra)
+		case <-ping_ticker.C:
+			err := b.listener.Ping()
+			if err != nil {
+				log.Printf("Event listener ping failed: %s", err)
+			}
+		}
+	}
+}
+
+func (b *PGBridge) handleNotification(raw_payload string) {
+	payload := notifyPayload{}
+	err := json.Unmarshal([]byte(raw_payload), &payload)
+	if err != nil {
+		log.Printf("Error decoding event notification: %s", err)
+		return
+	}
+	if payload.Origin == b.instanceID {
+		return
+	}
+	b.hub.Publish(payload.Event)
+}
+
+func (b *PGBridge) Close() error {
+	return b.listener.Close()
+}
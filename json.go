@@ -0,0 +1,19 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"encoding/json"
+)
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	response_data, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(response_data)
+}
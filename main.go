@@ -3,6 +3,11 @@ package main
 import (
 	"os"
 	"log"
+	"errors"
+	"context"
+	"time"
+	"strconv"
+	"strings"
 	"net/http"
 	"sync/atomic"
 	"database/sql"
@@ -10,15 +15,34 @@ import (
 	_ "github.com/lib/pq"
 	"github.com/joho/godotenv"
 
+	"github.com/t6kke/chirpy/internal/activitypub"
+	"github.com/t6kke/chirpy/internal/auth"
 	"github.com/t6kke/chirpy/internal/database"
+	"github.com/t6kke/chirpy/internal/mailer"
+	"github.com/t6kke/chirpy/internal/oidc"
+	"github.com/t6kke/chirpy/internal/passwordpolicy"
+	"github.com/t6kke/chirpy/internal/pubsub"
 )
 
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	dbq            *database.Queries
-	platform       string
-	c_secret       string
-	p_key          string
+	fileserverHits        atomic.Int32
+	db                    *sql.DB
+	dbq                   *database.Queries
+	platform              string
+	keyring               *auth.Keyring
+	denylist              *auth.Denylist
+	p_key                 string
+	hub                   *pubsub.Hub
+	publisher             pubsub.Publisher
+	public_url            string
+	ap_client             *activitypub.Client
+	mailer                mailer.Mailer
+	unverified_policy     string
+	oidc_client           *oidc.Client
+	oidc_redirect_url     string
+	password_policy       *passwordpolicy.Policy
+	introspection_clients map[string]string
+	polka_verifier        *auth.WebhookVerifier
 }
 
 func main() {
@@ -31,13 +55,53 @@ func main() {
 	if platform == "" {
 		log.Fatal("PLATFORM must be set")
 	}
-	chirpy_secret := os.Getenv("CHIRPY_SECRET")
-	if chirpy_secret == "" {
-		log.Fatal("CHIRPY_SECRET must be set")
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.Fatalf("failed to load JWT keys: %v", err)
 	}
 	polka_key := os.Getenv("POLKA_KEY")
-	if polka_key == "" {
-		log.Fatal("POLKA_KEY must be set")
+	polka_verifier, err := loadPolkaVerifier()
+	if err != nil {
+		log.Fatalf("failed to set up Polka webhook signatures: %v", err)
+	}
+	if polka_key == "" && polka_verifier == nil {
+		log.Fatal("POLKA_KEY or POLKA_WEBHOOK_SECRETS must be set")
+	}
+	if polka_verifier == nil {
+		log.Printf("POLKA_WEBHOOK_SECRETS is not set, Polka webhooks are only checked with the API key and can be replayed")
+	}
+	mail_sender, err := loadMailer(platform)
+	if err != nil {
+		log.Fatalf("failed to set up mailer: %v", err)
+	}
+	unverified_policy := os.Getenv("UNVERIFIED_EMAIL_POLICY")
+	if unverified_policy == "" {
+		unverified_policy = UnverifiedPolicyAllow
+	}
+	if unverified_policy != UnverifiedPolicyAllow && unverified_policy != UnverifiedPolicyNoPosting {
+		log.Fatal("UNVERIFIED_EMAIL_POLICY must be allow or no-posting")
+	}
+	// federation is only enabled when we know the public address other servers reach us at
+	public_url := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	// links in emails that are actually delivered need the public address
+	if public_url == "" && os.Getenv("MAILER") != "" && os.Getenv("MAILER") != "log" {
+		log.Fatal("PUBLIC_URL must be set when MAILER is smtp or file")
+	}
+	oidc_client, oidc_redirect_url, err := loadOIDCClient(public_url)
+	if err != nil {
+		log.Fatalf("failed to set up OIDC login: %v", err)
+	}
+	password_policy, err := loadPasswordPolicy()
+	if err != nil {
+		log.Fatalf("failed to load password policy: %v", err)
+	}
+	// client ID to secret of services allowed to use token introspection
+	introspection_clients := map[string]string{}
+	if os.Getenv("INTROSPECTION_CLIENTS") != "" {
+		introspection_clients, err = auth.ParseKeyringKeys(os.Getenv("INTROSPECTION_CLIENTS"))
+		if err != nil {
+			log.Fatalf("failed to parse INTROSPECTION_CLIENTS: %v", err)
+		}
 	}
 	const filepathRoot = "."
 	const port = "8080"
@@ -48,32 +112,101 @@ func main() {
 	}
 	defer db.Close()
 	dbQueries := database.New(db)
+	denylist := auth.NewDenylist(dbQueries, 30*time.Second)
+	keyring.SetDenylist(denylist)
 
-	var counter atomic.Int32
+	hub := pubsub.NewHub()
 	api_cfg := apiConfig{
-		fileserverHits: counter,
-		dbq:            dbQueries,
-		platform:       platform,
-		c_secret:       chirpy_secret,
-		p_key:          polka_key,
+		db:                    db,
+		dbq:                   dbQueries,
+		platform:              platform,
+		keyring:               keyring,
+		denylist:              denylist,
+		p_key:                 polka_key,
+		hub:                   hub,
+		publisher:             hub,
+		public_url:            public_url,
+		ap_client:             activitypub.NewClient("chirpy"),
+		mailer:                mail_sender,
+		unverified_policy:     unverified_policy,
+		oidc_client:           oidc_client,
+		oidc_redirect_url:     oidc_redirect_url,
+		password_policy:       password_policy,
+		introspection_clients: introspection_clients,
+		polka_verifier:        polka_verifier,
+	}
+
+	// with more than one instance running events need to be shared through postgres
+	if os.Getenv("EVENTS_FANOUT") == "postgres" {
+		bridge, err := pubsub.NewPGBridge(hub, db, dbURL)
+		if err != nil {
+			log.Fatalf("failed to start event listener: %v", err)
+		}
+		defer bridge.Close()
+		go bridge.Run(context.Background())
+		api_cfg.publisher = bridge
 	}
 
 	server_mux := http.NewServeMux()
 	file_server := http.FileServer(http.Dir(filepathRoot))
 	server_mux.Handle("/app/", api_cfg.middlewareMetricsInc(http.StripPrefix("/app", file_server)))
 	server_mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	server_mux.HandleFunc("GET /.well-known/jwks.json", api_cfg.handlerJWKS)
 	server_mux.HandleFunc("GET /api/chirps", api_cfg.handlerGetAllChirps)
 	server_mux.HandleFunc("POST /api/chirps", api_cfg.handlerAddChirp)
 	server_mux.HandleFunc("GET /api/chirps/{chirpID}", api_cfg.handlerGetOneChirp)
 	server_mux.HandleFunc("DELETE /api/chirps/{chirpID}", api_cfg.handlerDeleteOneChirp)
+	server_mux.Handle("DELETE /api/moderation/chirps/{chirpID}", api_cfg.middlewareRequireRole(RoleModerator, api_cfg.handlerModerateDeleteChirp))
+	server_mux.HandleFunc("GET /api/feed.rss", api_cfg.handlerGlobalFeedRSS)
+	server_mux.HandleFunc("GET /api/feed.atom", api_cfg.handlerGlobalFeedAtom)
+	server_mux.HandleFunc("GET /api/users/{userID}/feed.rss", api_cfg.handlerUserFeedRSS)
+	server_mux.HandleFunc("GET /api/users/{userID}/feed.atom", api_cfg.handlerUserFeedAtom)
+	server_mux.HandleFunc("GET /api/stream/chirps", api_cfg.handlerStreamChirps)
+	server_mux.HandleFunc("GET /api/ws", api_cfg.handlerWebSocket)
 	server_mux.HandleFunc("POST /api/users", api_cfg.handlerAddUser)
 	server_mux.HandleFunc("PUT /api/users", api_cfg.handlerUpdateUserPwEm)
 	server_mux.HandleFunc("POST /api/login", api_cfg.handlerUserLogin)
+	if oidc_client != nil {
+		server_mux.HandleFunc("GET /api/auth/oidc/login", api_cfg.handlerOIDCLogin)
+		server_mux.HandleFunc("GET /api/auth/oidc/callback", api_cfg.handlerOIDCCallback)
+	}
+	server_mux.HandleFunc("POST /api/login/mfa", api_cfg.handlerLoginMFA)
+	if len(introspection_clients) > 0 {
+		server_mux.HandleFunc("POST /api/oauth/introspect", api_cfg.handlerIntrospect)
+	}
+	server_mux.HandleFunc("GET /api/userinfo", api_cfg.handlerUserInfo)
+	server_mux.HandleFunc("POST /api/users/me/2fa/totp", api_cfg.handlerEnrollTOTP)
+	server_mux.HandleFunc("POST /api/users/me/2fa/totp/confirm", api_cfg.handlerConfirmTOTP)
+	server_mux.HandleFunc("DELETE /api/users/me/2fa/totp", api_cfg.handlerDisableTOTP)
 	server_mux.HandleFunc("POST /api/refresh", api_cfg.handlerRefreshToken)
 	server_mux.HandleFunc("POST /api/revoke", api_cfg.handlerRevokeToken)
+	server_mux.HandleFunc("GET /api/verify-email", api_cfg.handlerVerifyEmail)
+	server_mux.HandleFunc("POST /api/verify-email/resend", api_cfg.handlerResendVerification)
+	server_mux.HandleFunc("POST /api/password-reset/request", api_cfg.handlerPasswordResetRequest)
+	server_mux.HandleFunc("POST /api/password-reset/confirm", api_cfg.handlerPasswordResetConfirm)
+	server_mux.HandleFunc("POST /api/logout", api_cfg.handlerLogout)
+	server_mux.HandleFunc("POST /api/logout-all", api_cfg.handlerLogoutAll)
+	server_mux.HandleFunc("GET /api/users/me/tokens", api_cfg.handlerGetTokens)
+	server_mux.HandleFunc("POST /api/users/me/tokens", api_cfg.handlerCreateToken)
+	server_mux.HandleFunc("DELETE /api/users/me/tokens/{tokenID}", api_cfg.handlerDeleteToken)
+	server_mux.HandleFunc("GET /api/users/me/sessions", api_cfg.handlerGetSessions)
+	server_mux.HandleFunc("DELETE /api/users/me/sessions/{sessionID}", api_cfg.handlerDeleteSession)
 	server_mux.HandleFunc("POST /api/polka/webhooks", api_cfg.handlerPolkaPaymentUpgrade)
-	server_mux.HandleFunc("GET /admin/metrics", api_cfg.handlerMetrics)
-	server_mux.HandleFunc("POST /admin/reset", api_cfg.handlerReset)
+	if public_url != "" {
+		server_mux.HandleFunc("GET /.well-known/webfinger", api_cfg.handlerWebFinger)
+		server_mux.HandleFunc("GET /ap/users/{userID}", api_cfg.handlerActor)
+		server_mux.HandleFunc("GET /ap/users/{userID}/outbox", api_cfg.handlerOutbox)
+		server_mux.HandleFunc("GET /ap/users/{userID}/followers", api_cfg.handlerFollowers)
+		server_mux.HandleFunc("POST /ap/users/{userID}/inbox", api_cfg.handlerInbox)
+		server_mux.HandleFunc("GET /ap/chirps/{chirpID}", api_cfg.handlerActivityPubNote)
+	}
+	server_mux.Handle("GET /admin/metrics", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerMetrics))
+	server_mux.Handle("POST /admin/reset", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerReset))
+	server_mux.Handle("PUT /admin/users/{userID}/role", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerSetUserRole))
+	server_mux.Handle("GET /admin/lockouts", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerGetLoginLockouts))
+	server_mux.Handle("POST /admin/lockouts/{lockoutID}/unlock", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerUnlockLogin))
+	server_mux.Handle("GET /admin/webhooks", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerGetWebhookEvents))
+	server_mux.Handle("POST /admin/webhooks/{eventID}/replay", api_cfg.middlewareRequireRole(RoleAdmin, api_cfg.handlerReplayWebhookEvent))
 
 	server_struct := &http.Server{
 		Addr:    ":"+ port,
@@ -84,4 +217,180 @@ func main() {
 	log.Fatal(server_struct.ListenAndServe())
 }
 
+// loadKeyring uses CHIRPY_JWT_KEYS when set so secrets can be rotated,
+// otherwise CHIRPY_SECRET is the only HMAC key. Private keys for asymmetric
+// signing are loaded from the PEM files in CHIRPY_JWT_PRIVATE_KEYS.
+func loadKeyring() (*auth.Keyring, error) {
+	keyring := auth.NewEmptyKeyring()
+
+	jwt_keys := os.Getenv("CHIRPY_JWT_KEYS")
+	chirpy_secret := os.Getenv("CHIRPY_SECRET")
+	if jwt_keys != "" {
+		keys, err := auth.ParseKeyringKeys(jwt_keys)
+		if err != nil {
+			return nil, err
+		}
+		for key_id, secret := range keys {
+			err = keyring.AddHMACKey(key_id, secret)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if chirpy_secret != "" {
+		err := keyring.AddHMACKey(auth.DefaultKeyID, chirpy_secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	private_keys := os.Getenv("CHIRPY_JWT_PRIVATE_KEYS")
+	if private_keys != "" {
+		key_files, err := auth.ParseKeyringKeys(private_keys)
+		if err != nil {
+			return nil, err
+		}
+		for key_id, key_file := range key_files {
+			pem_data, err := os.ReadFile(key_file)
+			if err != nil {
+				return nil, err
+			}
+			err = keyring.AddPrivateKeyPEM(key_id, pem_data)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 
+	if jwt_keys == "" && chirpy_secret == "" && private_keys == "" {
+		return nil, errors.New("CHIRPY_SECRET, CHIRPY_JWT_KEYS or CHIRPY_JWT_PRIVATE_KEYS must be set")
+	}
+	err := keyring.SetSigningKey(os.Getenv("CHIRPY_JWT_SIGNING_KID"))
+	if err != nil {
+		return nil, err
+	}
+
+	// tokens have nbf of the moment they are made, without leeway a server
+	// with clock slightly behind would reject fresh tokens
+	leeway := 30 * time.Second
+	if os.Getenv("CHIRPY_JWT_LEEWAY") != "" {
+		leeway, err = time.ParseDuration(os.Getenv("CHIRPY_JWT_LEEWAY"))
+		if err != nil || leeway < 0 || leeway > 5*time.Minute {
+			return nil, errors.New("CHIRPY_JWT_LEEWAY must be a duration between 0s and 5m")
+		}
+	}
+	keyring.SetLeeway(leeway)
+	return keyring, nil
+}
+
+// loadMailer picks how emails are sent with MAILER. The log mailer writes reset
+// tokens and verification links to the log so outside dev it has to be chosen
+// explicitly.
+func loadMailer(platform string) (mailer.Mailer, error) {
+	mail_from := os.Getenv("MAIL_FROM")
+	if mail_from == "" {
+		mail_from = "chirpy@localhost"
+	}
+
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		smtp_addr := os.Getenv("SMTP_ADDR")
+		if smtp_addr == "" {
+			return nil, errors.New("SMTP_ADDR must be set when MAILER is smtp")
+		}
+		return mailer.NewSMTPMailer(smtp_addr, mail_from, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	case "file":
+		mail_dir := os.Getenv("MAIL_DIR")
+		if mail_dir == "" {
+			mail_dir = "mail"
+		}
+		return mailer.NewFileMailer(mail_dir, mail_from)
+	case "":
+		if platform != "dev" {
+			return nil, errors.New("MAILER must be set when PLATFORM is not dev")
+		}
+		return mailer.NewLogMailer(mail_from), nil
+	case "log":
+		log.Printf("MAILER is log, password reset tokens and verification links are written to the log")
+		return mailer.NewLogMailer(mail_from), nil
+	}
+	return nil, errors.New("MAILER must be smtp, file or log")
+}
+
+// loadPolkaVerifier gives nil without POLKA_WEBHOOK_SECRETS. During rotation
+// both the new and the old secret are listed.
+func loadPolkaVerifier() (*auth.WebhookVerifier, error) {
+	if os.Getenv("POLKA_WEBHOOK_SECRETS") == "" {
+		return nil, nil
+	}
+	secrets := strings.Split(os.Getenv("POLKA_WEBHOOK_SECRETS"), ",")
+	for i := range secrets {
+		secrets[i] = strings.TrimSpace(secrets[i])
+	}
+
+	tolerance := 5 * time.Minute
+	if os.Getenv("POLKA_WEBHOOK_TOLERANCE") != "" {
+		var err error
+		tolerance, err = time.ParseDuration(os.Getenv("POLKA_WEBHOOK_TOLERANCE"))
+		if err != nil || tolerance <= 0 {
+			return nil, errors.New("POLKA_WEBHOOK_TOLERANCE must be a positive duration")
+		}
+	}
+	return auth.NewWebhookVerifier(secrets, tolerance)
+}
+
+// loadPasswordPolicy has length limits of 8 to 256 by default, banned list
+// and breach check are only used when configured
+func loadPasswordPolicy() (*passwordpolicy.Policy, error) {
+	min_length, max_length := 8, 256
+	var err error
+	if os.Getenv("PASSWORD_MIN_LENGTH") != "" {
+		min_length, err = strconv.Atoi(os.Getenv("PASSWORD_MIN_LENGTH"))
+		if err != nil || min_length < 1 {
+			return nil, errors.New("PASSWORD_MIN_LENGTH must be a positive number")
+		}
+	}
+	if os.Getenv("PASSWORD_MAX_LENGTH") != "" {
+		max_length, err = strconv.Atoi(os.Getenv("PASSWORD_MAX_LENGTH"))
+		if err != nil || max_length < min_length {
+			return nil, errors.New("PASSWORD_MAX_LENGTH must be a number not smaller than PASSWORD_MIN_LENGTH")
+		}
+	}
+
+	policy := passwordpolicy.New(min_length, max_length)
+	if os.Getenv("PASSWORD_BANNED_FILE") != "" {
+		err = policy.LoadBannedFile(os.Getenv("PASSWORD_BANNED_FILE"))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if os.Getenv("PASSWORD_BREACH_URL") != "" {
+		policy.SetBreachChecker(passwordpolicy.NewRangeClient(os.Getenv("PASSWORD_BREACH_URL")))
+	}
+	return policy, nil
+}
+
+// loadOIDCClient enables login with external OpenID Connect provider when
+// OIDC_ISSUER is set
+func loadOIDCClient(public_url string) (*oidc.Client, string, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, "", nil
+	}
+	client_id := os.Getenv("OIDC_CLIENT_ID")
+	if client_id == "" {
+		return nil, "", errors.New("OIDC_CLIENT_ID must be set when OIDC_ISSUER is set")
+	}
+	redirect_url := os.Getenv("OIDC_REDIRECT_URL")
+	if redirect_url == "" && public_url != "" {
+		redirect_url = public_url + "/api/auth/oidc/callback"
+	}
+	if redirect_url == "" {
+		return nil, "", errors.New("OIDC_REDIRECT_URL or PUBLIC_URL must be set when OIDC_ISSUER is set")
+	}
+	return oidc.NewClient(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     client_id,
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  redirect_url,
+	}), redirect_url, nil
+}
@@ -0,0 +1,25 @@
+-- name: RevokeAccessToken :execrows
+INSERT INTO revoked_access_tokens (jti, user_id, revoked_at, expires_at)
+VALUES ($1, $2, NOW(), $3)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: IsAccessTokenRevoked :one
+SELECT EXISTS (
+	SELECT 1 FROM revoked_access_tokens
+	WHERE jti = $1
+);
+
+-- name: DeleteExpiredRevokedAccessTokens :exec
+DELETE FROM revoked_access_tokens
+WHERE expires_at < NOW();
+
+-- compared to JWT iat in Go so it's stored in UTC, at full precision so
+-- tokens issued in the same second can't slip through
+-- name: RevokeAllUserAccessTokens :exec
+UPDATE users
+SET tokens_revoked_before = NOW() AT TIME ZONE 'UTC'
+WHERE id = $1;
+
+-- name: GetTokensRevokedBefore :one
+SELECT tokens_revoked_before FROM users
+WHERE id = $1;
@@ -0,0 +1,28 @@
+-- name: CreateActorKey :one
+INSERT INTO actor_keys (user_id, created_at, public_key_pem, private_key_pem)
+VALUES ($1, NOW(), $2, $3)
+RETURNING *;
+
+-- name: GetActorKey :one
+SELECT * FROM actor_keys
+WHERE user_id = $1;
+
+-- name: AddFollower :exec
+INSERT INTO followers (user_id, actor_id, inbox, created_at, updated_at)
+VALUES ($1, $2, $3, NOW(), NOW())
+ON CONFLICT (user_id, actor_id) DO UPDATE
+SET inbox = EXCLUDED.inbox, updated_at = NOW();
+
+-- name: RemoveFollower :exec
+DELETE FROM followers
+WHERE user_id = $1 and actor_id = $2;
+
+-- name: GetFollowers :many
+SELECT * FROM followers
+WHERE user_id = $1
+order by created_at ASC;
+
+-- name: CreateRemoteNote :exec
+INSERT INTO remote_notes (id, actor_id, content, published_at, received_at)
+VALUES ($1, $2, $3, $4, NOW())
+ON CONFLICT (id) DO NOTHING;
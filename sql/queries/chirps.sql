@@ -19,3 +19,24 @@ WHERE id = $1;
 -- name: DeleteOneChirp :exec
 DELETE FROM chirps
 WHERE ID = $1;
+
+-- name: GetNewestChirps :many
+SELECT * FROM chirps
+ORDER BY created_at DESC
+LIMIT $1;
+
+-- name: GetNewestUserChirps :many
+SELECT * FROM chirps
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- name: CountUserChirps :one
+SELECT COUNT(*) FROM chirps
+WHERE user_id = $1;
+
+-- name: GetUserChirpsPage :many
+SELECT * FROM chirps
+WHERE user_id = $1
+ORDER BY created_at DESC, id DESC
+LIMIT $2 OFFSET $3;
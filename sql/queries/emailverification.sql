@@ -0,0 +1,20 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token_hash, created_at, expires_at, email, user_id)
+VALUES ($1, NOW(), NOW() + INTERVAL '48 hours', $2, $3);
+
+-- name: UseEmailVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE expires_at > NOW() and token_hash = $1
+RETURNING *;
+
+-- name: DeleteUserEmailVerificationTokens :exec
+DELETE FROM email_verification_tokens
+WHERE user_id = $1;
+
+-- the token is only valid for the email it was sent to, not for an address
+-- the user changed to afterwards
+-- name: MarkEmailVerified :one
+UPDATE users
+SET updated_at = NOW(), email_verified_at = NOW()
+WHERE id = $1 and email = $2
+RETURNING *;
@@ -0,0 +1,70 @@
+-- name: GetLoginRetryAfter :one
+SELECT COALESCE(CEIL(EXTRACT(EPOCH FROM MAX(blocked_until) - NOW())), 0)::INTEGER AS retry_after
+FROM login_throttles
+WHERE ((kind = 'account' and subject = sqlc.arg(email)) or (kind = 'ip' and subject = sqlc.arg(ip)))
+and blocked_until > NOW();
+
+-- attempts in progress are counted in pending so parallel attempts can't all
+-- get past the check before any failure is recorded. Within free attempts
+-- they can run in parallel as long as all failing would not go over, after
+-- that only one at a time. Pending count older than a minute is from a
+-- request that never finished and is ignored. No row when the attempt is
+-- not allowed.
+-- name: ClaimLoginAttempt :one
+INSERT INTO login_throttles (kind, subject, failures, last_failure_at, blocked_until, pending, pending_since)
+VALUES ($1, $2, 0, NOW(), NULL, 1, NOW())
+ON CONFLICT (kind, subject) DO UPDATE
+SET pending = CASE WHEN login_throttles.pending_since < NOW() - INTERVAL '1 minute' THEN 1 ELSE login_throttles.pending + 1 END,
+    pending_since = NOW()
+WHERE (login_throttles.blocked_until is NULL or login_throttles.blocked_until <= NOW())
+and (login_throttles.pending = 0
+    or login_throttles.pending_since < NOW() - INTERVAL '1 minute'
+    or (CASE WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 hour' THEN 0 ELSE login_throttles.failures END)
+        + login_throttles.pending < sqlc.arg(free_attempts)::INTEGER)
+RETURNING failures;
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_throttles
+SET pending = GREATEST(pending - 1, 0)
+WHERE kind = $1 and subject = $2;
+
+-- failures are forgotten after an hour without new ones
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (kind, subject, failures, last_failure_at, blocked_until)
+VALUES ($1, $2, 1, NOW(), NULL)
+ON CONFLICT (kind, subject) DO UPDATE
+SET failures = CASE WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 hour' THEN 1 ELSE login_throttles.failures + 1 END,
+    last_failure_at = NOW(),
+    pending = GREATEST(login_throttles.pending - 1, 0)
+RETURNING failures;
+
+-- name: BlockLogin :exec
+UPDATE login_throttles
+SET blocked_until = NOW() + sqlc.arg(seconds)::INTEGER * INTERVAL '1 second'
+WHERE kind = $1 and subject = $2;
+
+-- name: ClearLoginThrottle :exec
+DELETE FROM login_throttles
+WHERE kind = $1 and subject = $2;
+
+-- name: DeleteStaleLoginThrottles :exec
+DELETE FROM login_throttles
+WHERE last_failure_at < NOW() - INTERVAL '1 day' and (blocked_until is NULL or blocked_until < NOW());
+
+-- name: CreateLoginLockout :exec
+INSERT INTO login_lockouts (id, kind, subject, user_id, failures, created_at, locked_until, unlocked_at, unlocked_by)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW(), NOW() + sqlc.arg(seconds)::INTEGER * INTERVAL '1 second', NULL, NULL);
+
+-- name: GetLoginLockouts :many
+SELECT * FROM login_lockouts
+ORDER BY created_at DESC
+LIMIT 100;
+
+-- name: GetLoginLockout :one
+SELECT * FROM login_lockouts
+WHERE id = $1;
+
+-- name: UnlockLoginLockouts :exec
+UPDATE login_lockouts
+SET unlocked_at = NOW(), unlocked_by = $3
+WHERE kind = $1 and subject = $2 and unlocked_at is NULL;
@@ -0,0 +1,20 @@
+-- name: CreateOIDCLoginState :exec
+INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, created_at, expires_at)
+VALUES ($1, $2, $3, NOW(), NOW() + INTERVAL '10 minutes');
+
+-- name: UseOIDCLoginState :one
+DELETE FROM oidc_login_states
+WHERE expires_at > NOW() and state_hash = $1
+RETURNING *;
+
+-- name: DeleteExpiredOIDCLoginStates :exec
+DELETE FROM oidc_login_states
+WHERE expires_at < NOW();
+
+-- name: GetUserIDByIdentity :one
+SELECT user_id FROM user_identities
+WHERE issuer = $1 and subject = $2;
+
+-- name: CreateUserIdentity :exec
+INSERT INTO user_identities (issuer, subject, user_id, created_at)
+VALUES ($1, $2, $3, NOW());
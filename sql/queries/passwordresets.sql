@@ -0,0 +1,13 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens (token_hash, created_at, expires_at, used_at, user_id)
+VALUES ($1, NOW(), NOW() + INTERVAL '1 hour', NULL, $2);
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens
+SET used_at = NOW()
+WHERE used_at is NULL and expires_at > NOW() and token_hash = $1
+RETURNING user_id;
+
+-- name: DeleteUserPasswordResetTokens :exec
+DELETE FROM password_reset_tokens
+WHERE user_id = $1;
@@ -0,0 +1,23 @@
+-- created_at is compared to users.tokens_revoked_before so it's stored in UTC
+-- name: CreatePersonalAccessToken :one
+INSERT INTO personal_access_tokens (id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW() AT TIME ZONE 'UTC', NULL, $5, NULL)
+RETURNING *;
+
+-- name: GetUserPersonalAccessTokens :many
+SELECT * FROM personal_access_tokens
+WHERE user_id = $1 and revoked_at is NULL and (expires_at is NULL or expires_at > NOW())
+    and created_at >= (SELECT tokens_revoked_before FROM users WHERE users.id = $1)
+ORDER BY created_at DESC;
+
+-- name: UsePersonalAccessToken :one
+UPDATE personal_access_tokens
+SET last_used_at = NOW()
+WHERE token_hash = $1 and revoked_at is NULL and (expires_at is NULL or expires_at > NOW())
+RETURNING *;
+
+-- name: RevokePersonalAccessToken :execrows
+UPDATE personal_access_tokens
+SET revoked_at = NOW()
+WHERE id = $1 and user_id = $2 and revoked_at is NULL;
+
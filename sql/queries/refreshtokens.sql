@@ -1,14 +1,44 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, expires_at, revoked_at, user_id)
-VALUES ($1, NOW(), NOW(), $2, NULL, $3)
+INSERT INTO refresh_tokens (token_hash, created_at, updated_at, expires_at, revoked_at, user_id, family_id, user_agent, ip, last_used_at)
+VALUES ($1, NOW(), NOW(), $2, NULL, $3, $4, $5, $6, NOW())
 RETURNING *;
 
 -- name: GetUserFromRefreshToken :one
 SELECT user_id from refresh_tokens
-WHERE revoked_at is NULL and expires_at > NOW() and token = $1;
+WHERE revoked_at is NULL and expires_at > NOW() and token_hash = $1;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE token_hash = $1;
 
 -- name: RevokeRefreshToken :one
 UPDATE refresh_tokens
 SET updated_at = NOW(), revoked_at = NOW()
-WHERE token = $1
+WHERE token_hash = $1
+RETURNING *;
+
+-- name: RevokeActiveRefreshToken :one
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE revoked_at is NULL and expires_at > NOW() and token_hash = $1
 RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE revoked_at is NULL and family_id = $1;
+
+-- name: GetUserSessions :many
+SELECT * FROM refresh_tokens
+WHERE revoked_at is NULL and expires_at > NOW() and user_id = $1
+order by last_used_at DESC;
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE revoked_at is NULL and user_id = $1 and family_id = $2;
+
+-- name: RevokeAllUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET updated_at = NOW(), revoked_at = NOW()
+WHERE revoked_at is NULL and user_id = $1;
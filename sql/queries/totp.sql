@@ -0,0 +1,50 @@
+-- enrolling again before confirming replaces the pending secret
+-- name: UpsertPendingTOTP :exec
+INSERT INTO user_totp (user_id, secret, created_at, confirmed_at, last_used_step, failed_attempts)
+VALUES ($1, $2, NOW(), NULL, 0, 0)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, created_at = NOW(), last_used_step = 0, failed_attempts = 0
+WHERE user_totp.confirmed_at is NULL;
+
+-- name: GetUserTOTP :one
+SELECT * FROM user_totp
+WHERE user_id = $1;
+
+-- name: ConfirmTOTP :execrows
+UPDATE user_totp
+SET confirmed_at = NOW(), last_used_step = $2
+WHERE user_id = $1 and confirmed_at is NULL;
+
+-- only later steps are accepted so the same code can't be used twice
+-- name: UseTOTPStep :execrows
+UPDATE user_totp
+SET last_used_step = $2, failed_attempts = 0
+WHERE user_id = $1 and last_used_step < $2;
+
+-- name: IncrementTOTPFailedAttempts :one
+UPDATE user_totp
+SET failed_attempts = failed_attempts + 1
+WHERE user_id = $1
+RETURNING failed_attempts;
+
+-- name: ResetTOTPFailedAttempts :exec
+UPDATE user_totp
+SET failed_attempts = 0
+WHERE user_id = $1;
+
+-- name: DeleteUserTOTP :exec
+DELETE FROM user_totp
+WHERE user_id = $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes (code_hash, user_id, created_at, used_at)
+VALUES ($1, $2, NOW(), NULL);
+
+-- name: DeleteUserRecoveryCodes :exec
+DELETE FROM recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 and code_hash = $2 and used_at is NULL;
@@ -12,7 +12,9 @@ WHERE email = $1;
 
 -- name: UpdatePasswordAndEmail :one
 UPDATE users
-SET updated_at = NOW(), email = $2, hashed_password = $3
+SET updated_at = NOW(), email = $2, hashed_password = $3,
+    email_verified_at = CASE WHEN email = $2 THEN email_verified_at ELSE NULL END,
+    tokens_revoked_before = NOW() AT TIME ZONE 'UTC'
 WHERE id = $1
 RETURNING *;
 
@@ -21,3 +23,29 @@ UPDATE users
 SET updated_at = NOW(), is_chirpy_red = true
 WHERE id = $1
 RETURNING *;
+
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
+
+-- name: UpdatePassword :exec
+UPDATE users
+SET updated_at = NOW(), hashed_password = $2, tokens_revoked_before = NOW() AT TIME ZONE 'UTC'
+WHERE id = $1;
+
+-- name: GetUserRole :one
+SELECT role FROM users
+WHERE id = $1;
+
+-- name: SetUserRole :one
+UPDATE users
+SET updated_at = NOW(), role = $2
+WHERE id = $1
+RETURNING *;
+
+-- only replaces the hash it was computed from, a password change at the
+-- same time wins
+-- name: RehashPassword :exec
+UPDATE users
+SET hashed_password = sqlc.arg(new_hash)
+WHERE id = $1 and hashed_password = sqlc.arg(old_hash);
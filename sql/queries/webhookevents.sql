@@ -0,0 +1,35 @@
+-- retried delivery of the same event only bumps attempts
+-- name: RecordWebhookEvent :one
+INSERT INTO webhook_events (id, source, event_id, event_type, payload, status, error, attempts, received_at, processed_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, 'received', NULL, 1, NOW(), NULL)
+ON CONFLICT (source, event_id) DO UPDATE
+SET attempts = webhook_events.attempts + 1
+RETURNING *;
+
+-- only one delivery gets to process the event, replay can also take events
+-- that are already done. Claims older than 5 minutes are from a delivery that
+-- never finished and can be taken over
+-- name: ClaimWebhookEvent :one
+UPDATE webhook_events
+SET status = 'processing', claimed_at = NOW()
+WHERE id = $1
+AND (status IN ('received', 'failed')
+    OR (sqlc.arg(replay)::BOOLEAN AND status IN ('processed', 'ignored'))
+    OR (status = 'processing' AND claimed_at < NOW() - INTERVAL '5 minutes'))
+RETURNING *;
+
+-- name: SetWebhookEventStatus :one
+UPDATE webhook_events
+SET status = $2, error = $3, processed_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events
+WHERE id = $1;
+
+-- name: GetWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE sqlc.narg(status)::TEXT is NULL or status = sqlc.narg(status)::TEXT
+ORDER BY received_at DESC
+LIMIT 100;
@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE actor_keys (
+    user_id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    public_key_pem TEXT NOT NULL,
+    private_key_pem TEXT NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE followers (
+    user_id UUID NOT NULL,
+    actor_id TEXT NOT NULL,
+    inbox TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, actor_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE remote_notes (
+    id TEXT PRIMARY KEY,
+    actor_id TEXT NOT NULL,
+    content TEXT NOT NULL,
+    published_at TIMESTAMP,
+    received_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE remote_notes;
+DROP TABLE followers;
+DROP TABLE actor_keys;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- existing tokens keep working, they are hashed in place with the same
+-- SHA-256 hex encoding the application uses
+UPDATE refresh_tokens
+SET token = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token TO token_hash;
+
+-- +goose Down
+-- hashes can't be turned back to tokens so all sessions are invalidated
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens
+RENAME COLUMN token_hash TO token;
@@ -0,0 +1,16 @@
+-- +goose Up
+CREATE TABLE revoked_access_tokens (
+	jti TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users ON DELETE CASCADE,
+	revoked_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE users
+ADD COLUMN tokens_revoked_before TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+
+-- +goose Down
+ALTER TABLE users
+DROP COLUMN tokens_revoked_before;
+
+DROP TABLE revoked_access_tokens;
@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE login_throttles (
+    kind TEXT NOT NULL CHECK (kind IN ('account', 'ip')),
+    subject TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP NOT NULL,
+    blocked_until TIMESTAMP,
+    PRIMARY KEY (kind, subject)
+);
+
+CREATE TABLE login_lockouts (
+    id UUID PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('account', 'ip')),
+    subject TEXT NOT NULL,
+    user_id UUID,
+    failures INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    locked_until TIMESTAMP NOT NULL,
+    unlocked_at TIMESTAMP,
+    unlocked_by UUID,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (unlocked_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX login_lockouts_created_at_idx ON login_lockouts (created_at);
+
+-- +goose Down
+DROP TABLE login_lockouts;
+DROP TABLE login_throttles;
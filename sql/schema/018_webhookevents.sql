@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE webhook_events (
+    id UUID PRIMARY KEY,
+    source TEXT NOT NULL,
+    event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('received', 'processed', 'ignored', 'failed')),
+    error TEXT,
+    attempts INTEGER NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP,
+    UNIQUE (source, event_id)
+);
+
+CREATE INDEX webhook_events_received_at_idx ON webhook_events (received_at);
+
+-- +goose Down
+DROP TABLE webhook_events;
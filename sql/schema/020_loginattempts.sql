@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE login_throttles
+ADD COLUMN pending INTEGER NOT NULL DEFAULT 0,
+ADD COLUMN pending_since TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE login_throttles
+DROP COLUMN pending_since,
+DROP COLUMN pending;
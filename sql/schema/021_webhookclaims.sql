@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE webhook_events
+DROP CONSTRAINT webhook_events_status_check,
+ADD CONSTRAINT webhook_events_status_check CHECK (status IN ('received', 'processing', 'processed', 'ignored', 'failed')),
+ADD COLUMN claimed_at TIMESTAMP;
+
+-- +goose Down
+UPDATE webhook_events SET status = 'failed' WHERE status = 'processing';
+ALTER TABLE webhook_events
+DROP COLUMN claimed_at,
+DROP CONSTRAINT webhook_events_status_check,
+ADD CONSTRAINT webhook_events_status_check CHECK (status IN ('received', 'processed', 'ignored', 'failed'));